@@ -0,0 +1,134 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrAutocommitUnsupported is returned by Autocommit if the PgxHandle given to New can't run queries outside a transaction,
+// and by CopyFrom and SendBatch on its *Q if the PgxHandle doesn't support those.
+var ErrAutocommitUnsupported = errors.New("trxwrap: handle doesn't support queries outside a transaction")
+
+// Autocommit returns a *Q that runs every statement directly on the pool, without an explicit transaction.
+// This saves the roundtrips for BEGIN and COMMIT when you only need a single statement.
+// Statements are retried on transient errors like RunTransaction would. Statements that don't write are considered idempotent.
+// The PgxHandle given to New must have Exec, Query and QueryRow methods (like *pgxpool.Pool has), or ErrAutocommitUnsupported is returned.
+func (t TrxWrap[Q]) Autocommit() (*Q, error) {
+	h, ok := t.db.(autocommitHandle)
+	if !ok {
+		return nil, ErrAutocommitUnsupported
+	}
+	return t.gendb(autocommitDB{db: t.db, h: h, opts: &t.opts}), nil
+}
+
+type autocommitHandle interface {
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+}
+
+type autocommitDB struct {
+	db   PgxHandle
+	h    autocommitHandle
+	opts *options
+}
+
+func (a autocommitDB) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	name := QueryName(query)
+	ctx, e := startQuery(withQueryName(ctx, name), a.opts.queryHooks, false, QueryOpExec, query, name, args)
+	var ct pgconn.CommandTag
+	err := retry(ctx, func() (bool, error) {
+		var err error
+		ct, err = a.h.Exec(ctx, query, args...)
+		return true, wrapQueryError(err, name)
+	}, isReadOnlyQuery(query))
+	endQuery(ctx, a.opts.queryHooks, e, ct.RowsAffected(), err)
+	return ct, err
+}
+
+func (a autocommitDB) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	name := QueryName(query)
+	ctx, e := startQuery(withQueryName(ctx, name), a.opts.queryHooks, false, QueryOpQuery, query, name, args)
+	// Only sending the query is retried. Errors while reading the rows are returned as is.
+	var rows pgx.Rows
+	err := retry(ctx, func() (bool, error) {
+		var err error
+		rows, err = a.h.Query(ctx, query, args...)
+		return true, wrapQueryError(err, name)
+	}, isReadOnlyQuery(query))
+	if err != nil {
+		endQuery(ctx, a.opts.queryHooks, e, 0, err)
+		return nil, err
+	}
+	wr := &wrappedRows{
+		Rows: rows,
+		name: name,
+	}
+	wr.release = func() {
+		endQuery(ctx, a.opts.queryHooks, e, rows.CommandTag().RowsAffected(), wr.Err())
+	}
+	return wr, nil
+}
+
+func (a autocommitDB) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	return autocommitRow{a, ctx, query, args}
+}
+
+// autocommitRow runs the query once Scan is called, so the whole statement can be retried.
+type autocommitRow struct {
+	a     autocommitDB
+	ctx   context.Context
+	query string
+	args  []interface{}
+}
+
+func (r autocommitRow) Scan(dest ...interface{}) error {
+	name := QueryName(r.query)
+	ctx, e := startQuery(withQueryName(r.ctx, name), r.a.opts.queryHooks, false, QueryOpQueryRow, r.query, name, r.args)
+	err := retry(ctx, func() (bool, error) {
+		err := r.a.h.QueryRow(ctx, r.query, r.args...).Scan(dest...)
+		if err == pgx.ErrNoRows {
+			return true, err
+		}
+		return true, wrapQueryError(err, name)
+	}, isReadOnlyQuery(r.query))
+	endQuery(ctx, r.a.opts.queryHooks, e, 0, err)
+	return err
+}
+
+func (a autocommitDB) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	ctx, e := startQuery(ctx, a.opts.queryHooks, false, QueryOpCopyFrom, describeCopy(tableName, columnNames), "", nil)
+	h, ok := a.db.(interface {
+		CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error)
+	})
+	if !ok {
+		endQuery(ctx, a.opts.queryHooks, e, 0, ErrAutocommitUnsupported)
+		return 0, ErrAutocommitUnsupported
+	}
+	// The rows can't be read again, so this can't be retried.
+	n, err := h.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	err = wrapError(err)
+	endQuery(ctx, a.opts.queryHooks, e, n, err)
+	return n, err
+}
+
+func (a autocommitDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	sql, name := describeBatch(batchQueries(b))
+	ctx, e := startQuery(withQueryName(ctx, name), a.opts.queryHooks, false, QueryOpBatch, sql, name, nil)
+	h, ok := a.db.(interface {
+		SendBatch(context.Context, *pgx.Batch) pgx.BatchResults
+	})
+	if !ok {
+		endQuery(ctx, a.opts.queryHooks, e, 0, ErrAutocommitUnsupported)
+		return wrappedBatchResultsError{ErrAutocommitUnsupported}
+	}
+	return &wrappedBatchResults{
+		br: h.SendBatch(ctx, b),
+		finish: func(rowsAffected int64, err error) {
+			endQuery(ctx, a.opts.queryHooks, e, rowsAffected, err)
+		},
+	}
+}
@@ -0,0 +1,79 @@
+// Package backfill runs data migrations in many small transactions, rather than one big one that holds locks for a long time.
+//
+// A backfill walks over a key range in batches. Each batch runs in its own transaction together with saving a checkpoint, so a backfill that is interrupted continues where it left off when it's run again.
+package backfill
+
+import (
+	"context"
+
+	"github.com/Jille/trxwrap"
+	"github.com/jackc/pgx/v4"
+)
+
+// BatchFunc processes the next batch of keys after `after`, which is the zero value of K for the first batch.
+// It returns the last key it processed and how many items it processed. Returning n == 0 means the backfill is done.
+// It is called within a transaction and might be retried. ctx is the context of the transaction, so it can be passed to helpers like trxwrap.BulkUpsert.
+type BatchFunc[Q, K any] func(ctx context.Context, q *Q, after K) (last K, n int, err error)
+
+// Checkpointer persists the progress of a backfill, typically in a table.
+type Checkpointer[Q, K any] interface {
+	// Load returns the last saved key, or ok=false if the backfill hasn't started yet.
+	Load(ctx context.Context, q *Q) (key K, ok bool, err error)
+	// Save stores the last processed key. It is called in the same transaction as the batch, so they are committed atomically.
+	Save(ctx context.Context, q *Q, key K) error
+}
+
+// Backfill runs BatchFunc until all keys are processed.
+type Backfill[Q, K any] struct {
+	DB        trxwrap.Transactor[Q]
+	Isolation pgx.TxIsoLevel
+	Batch     BatchFunc[Q, K]
+	// Checkpoint persists progress. If nil, the backfill starts from the beginning every time.
+	Checkpoint Checkpointer[Q, K]
+	// Throttle is waited for before each batch.
+	Throttle []Throttler
+}
+
+// Run runs the backfill until it's done, ctx is canceled or a batch fails.
+func (b Backfill[Q, K]) Run(ctx context.Context) error {
+	var after K
+	if b.Checkpoint != nil {
+		err := b.DB.RunROTransactionContext(ctx, b.Isolation, func(ctx context.Context, q *Q) error {
+			key, ok, err := b.Checkpoint.Load(ctx, q)
+			if err != nil {
+				return err
+			}
+			if ok {
+				after = key
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		for _, t := range b.Throttle {
+			if err := t.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		var last K
+		var n int
+		err := b.DB.RunRWTransactionContext(ctx, b.Isolation, func(ctx context.Context, q *Q) error {
+			var err error
+			last, n, err = b.Batch(ctx, q, after)
+			if err != nil || n == 0 || b.Checkpoint == nil {
+				return err
+			}
+			return b.Checkpoint.Save(ctx, q, last)
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		after = last
+	}
+}
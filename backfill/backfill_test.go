@@ -0,0 +1,151 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jille/trxwrap"
+	"github.com/Jille/trxwrap/trxwraptest"
+	"github.com/jackc/pgx/v4"
+)
+
+type fakeQueries struct{}
+
+type runnerCtxKey struct{}
+
+// runnerCtxTransactor marks the context it gives to runners, so tests can check that it is passed on.
+type runnerCtxTransactor struct {
+	*trxwraptest.MockTransactor[fakeQueries]
+}
+
+func (t runnerCtxTransactor) RunTransactionContext(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner trxwrap.ContextRunner[fakeQueries], opts ...trxwrap.RunOption) error {
+	return t.MockTransactor.RunTransactionContext(context.WithValue(ctx, runnerCtxKey{}, true), txo, idempotent, runner, opts...)
+}
+
+func (t runnerCtxTransactor) RunRWTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.ContextRunner[fakeQueries], opts ...trxwrap.RunOption) error {
+	return t.RunTransactionContext(ctx, pgx.TxOptions{IsoLevel: isolationLevel, AccessMode: pgx.ReadWrite}, false, runner, opts...)
+}
+
+func (t runnerCtxTransactor) RunROTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.ContextRunner[fakeQueries], opts ...trxwrap.RunOption) error {
+	return t.RunTransactionContext(ctx, pgx.TxOptions{IsoLevel: isolationLevel, AccessMode: pgx.ReadOnly}, true, runner, opts...)
+}
+
+type memCheckpoint struct {
+	key   int
+	ok    bool
+	saved []int
+}
+
+func (c *memCheckpoint) Load(ctx context.Context, q *fakeQueries) (int, bool, error) {
+	if ctx.Value(runnerCtxKey{}) == nil {
+		return 0, false, errors.New("Load didn't get the runner's context")
+	}
+	return c.key, c.ok, nil
+}
+
+func (c *memCheckpoint) Save(ctx context.Context, q *fakeQueries, key int) error {
+	if ctx.Value(runnerCtxKey{}) == nil {
+		return errors.New("Save didn't get the runner's context")
+	}
+	c.saved = append(c.saved, key)
+	return nil
+}
+
+func TestRunResumesFromCheckpoint(t *testing.T) {
+	cp := &memCheckpoint{key: 4, ok: true}
+	var afters []int
+	b := Backfill[fakeQueries, int]{
+		DB: runnerCtxTransactor{&trxwraptest.MockTransactor[fakeQueries]{Q: &fakeQueries{}}},
+		Batch: func(ctx context.Context, q *fakeQueries, after int) (int, int, error) {
+			if ctx.Value(runnerCtxKey{}) == nil {
+				return 0, 0, errors.New("Batch didn't get the runner's context")
+			}
+			afters = append(afters, after)
+			last := after + 3
+			if last > 10 {
+				last = 10
+			}
+			return last, last - after, nil
+		},
+		Checkpoint: cp,
+	}
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := []int{4, 7, 10}; !reflect.DeepEqual(afters, want) {
+		t.Errorf("Batch was called with after=%v, want %v", afters, want)
+	}
+	if want := []int{7, 10}; !reflect.DeepEqual(cp.saved, want) {
+		t.Errorf("Save was called with %v, want %v", cp.saved, want)
+	}
+}
+
+func TestRunStopsWhenBatchIsEmpty(t *testing.T) {
+	m := &trxwraptest.MockTransactor[fakeQueries]{Q: &fakeQueries{}}
+	cp := &memCheckpoint{}
+	b := Backfill[fakeQueries, int]{
+		DB: runnerCtxTransactor{m},
+		Batch: func(ctx context.Context, q *fakeQueries, after int) (int, int, error) {
+			return 0, 0, nil
+		},
+		Checkpoint: cp,
+	}
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(cp.saved) != 0 {
+		t.Errorf("Save was called with %v, want no calls", cp.saved)
+	}
+	calls := m.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d transactions, want 2 (loading the checkpoint and one batch)", len(calls))
+	}
+	if calls[0].TxOptions.AccessMode != pgx.ReadOnly || calls[1].TxOptions.AccessMode != pgx.ReadWrite {
+		t.Errorf("got access modes %q and %q, want read only and read write", calls[0].TxOptions.AccessMode, calls[1].TxOptions.AccessMode)
+	}
+}
+
+func TestRunReturnsBatchError(t *testing.T) {
+	wantErr := errors.New("batch failed")
+	cp := &memCheckpoint{}
+	b := Backfill[fakeQueries, int]{
+		DB: runnerCtxTransactor{&trxwraptest.MockTransactor[fakeQueries]{Q: &fakeQueries{}}},
+		Batch: func(ctx context.Context, q *fakeQueries, after int) (int, int, error) {
+			return 0, 0, wantErr
+		},
+		Checkpoint: cp,
+	}
+	if err := b.Run(context.Background()); err != wantErr {
+		t.Errorf("Run() = %v, want %v", err, wantErr)
+	}
+	if len(cp.saved) != 0 {
+		t.Errorf("Save was called with %v, want no calls", cp.saved)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	r := RateLimit(interval)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := r.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*interval {
+		t.Errorf("3 batches took %v, want at least %v", elapsed, 2*interval)
+	}
+
+	r = RateLimit(time.Hour)
+	if err := r.Wait(context.Background()); err != nil {
+		t.Errorf("first Wait() = %v, want nil", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Wait(ctx); err != context.Canceled {
+		t.Errorf("second Wait() = %v, want %v", err, context.Canceled)
+	}
+}
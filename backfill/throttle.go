@@ -0,0 +1,91 @@
+package backfill
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Jille/trxwrap"
+	"github.com/jackc/pgx/v4"
+)
+
+// Throttler slows down a backfill.
+type Throttler interface {
+	// Wait blocks until the next batch may run.
+	Wait(ctx context.Context) error
+}
+
+// ThrottlerFunc is a Throttler implemented by a function.
+type ThrottlerFunc func(ctx context.Context) error
+
+func (f ThrottlerFunc) Wait(ctx context.Context) error {
+	return f(ctx)
+}
+
+// RateLimit returns a Throttler that starts at most one batch per interval.
+func RateLimit(interval time.Duration) Throttler {
+	return &rateLimiter{interval: interval}
+}
+
+type rateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	start := r.next
+	if start.Before(now) {
+		start = now
+	}
+	r.next = start.Add(r.interval)
+	r.mu.Unlock()
+	t := time.NewTimer(start.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReplicaLag returns a Throttler that waits until lag reports a replication lag of at most maxLag, checking every poll interval.
+func ReplicaLag(lag func(ctx context.Context) (time.Duration, error), maxLag, poll time.Duration) Throttler {
+	return ThrottlerFunc(func(ctx context.Context) error {
+		for {
+			l, err := lag(ctx)
+			if err != nil {
+				return err
+			}
+			if l <= maxLag {
+				return nil
+			}
+			t := time.NewTimer(poll)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+// PrimaryReplicaLag returns a function for ReplicaLag that reports the highest replay lag of any replica, as seen from pg_stat_replication on the primary.
+func PrimaryReplicaLag(db trxwrap.PgxHandle) func(ctx context.Context) (time.Duration, error) {
+	return func(ctx context.Context) (time.Duration, error) {
+		tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback(ctx)
+		var seconds float64
+		if err := tx.QueryRow(ctx, "SELECT COALESCE(EXTRACT(EPOCH FROM MAX(replay_lag)), 0)::float8 FROM pg_stat_replication").Scan(&seconds); err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+}
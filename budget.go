@@ -0,0 +1,105 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RunOption configures a single call to RunTransaction and friends.
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	maxStatements int
+	maxRepeats    int
+	maxRows       int
+	// dryRun is set by RunDryRun and receives the result of the last attempt.
+	dryRun *DryRunResult
+}
+
+// WithStatementBudget sets the number of statements a single attempt of the transaction is expected to execute at most.
+// Every statement queued in a batch counts separately.
+// Exceeding it calls Hooks.OnBudgetExceeded, and panics with a *BudgetExceededError in strict mode.
+func WithStatementBudget(n int) RunOption {
+	return func(o *runOptions) {
+		o.maxStatements = n
+	}
+}
+
+// WithRepeatBudget sets how often a single attempt of the transaction is expected to execute the same query at most.
+// This helps to find N+1 query patterns. Queries are identified by their sqlc name, or their SQL if they don't have one.
+// The FETCH and CLOSE statements of StreamCursor are not counted as repeats.
+// Exceeding it calls Hooks.OnBudgetExceeded, and panics with a *BudgetExceededError in strict mode.
+func WithRepeatBudget(n int) RunOption {
+	return func(o *runOptions) {
+		o.maxRepeats = n
+	}
+}
+
+// ErrBudgetExceeded is matched by BudgetExceededError.
+var ErrBudgetExceeded = errors.New("trxwrap: statement budget exceeded")
+
+// BudgetExceededError describes a transaction that executed more statements than its budget allows.
+// It is passed to Hooks.OnBudgetExceeded, and used to panic in strict mode.
+type BudgetExceededError struct {
+	// StartedAt is the location of the code that started the transaction.
+	StartedAt string
+	// Query is the query that was repeated too often, or empty if the total budget was exceeded.
+	Query string
+	// Budget is the budget that was exceeded.
+	Budget int
+}
+
+func (e *BudgetExceededError) Error() string {
+	if e.Query == "" {
+		return fmt.Sprintf("%v: transaction started at %s executed more than %d statements", ErrBudgetExceeded, e.StartedAt, e.Budget)
+	}
+	return fmt.Sprintf("%v: transaction started at %s executed %s more than %d times", ErrBudgetExceeded, e.StartedAt, e.Query, e.Budget)
+}
+
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// countStatement counts a statement against the budgets of this attempt. Each budget is only reported once.
+// It must be called after acquire succeeded. In strict mode, it ends the query event e, releases the transaction and panics if a budget is exceeded.
+func (t *wrappedTransaction) countStatement(ctx context.Context, e *QueryEvent, name, sql string) {
+	if t.run.maxStatements <= 0 && t.run.maxRepeats <= 0 {
+		return
+	}
+	key := name
+	if key == "" {
+		key = sql
+	}
+	t.mu.Lock()
+	t.statements++
+	total := t.statements
+	var repeats int
+	if !isCursorFetch(ctx) {
+		if t.perQuery == nil {
+			t.perQuery = map[string]int{}
+		}
+		t.perQuery[key]++
+		repeats = t.perQuery[key]
+	}
+	t.mu.Unlock()
+
+	var exceeded []*BudgetExceededError
+	if t.run.maxStatements > 0 && total == t.run.maxStatements+1 {
+		exceeded = append(exceeded, &BudgetExceededError{Budget: t.run.maxStatements})
+	}
+	if t.run.maxRepeats > 0 && repeats == t.run.maxRepeats+1 {
+		exceeded = append(exceeded, &BudgetExceededError{Query: key, Budget: t.run.maxRepeats})
+	}
+	for _, err := range exceeded {
+		err.StartedAt = describeCaller(t.pcs)
+		if h := t.opts.hooks.OnBudgetExceeded; h != nil {
+			h(ctx, err)
+		}
+	}
+	if len(exceeded) > 0 && t.opts.strict {
+		t.endQuery(ctx, e, 0, exceeded[0])
+		t.release()
+		panic(exceeded[0])
+	}
+}
@@ -0,0 +1,172 @@
+package trxwrap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrReadOnlyTransaction is returned when a statement that writes is executed in a read-only transaction.
+var ErrReadOnlyTransaction = errors.New("trxwrap: write in read-only transaction")
+
+type statementKind int
+
+const (
+	statementUnknown statementKind = iota
+	statementReadOnly
+	statementWrite
+)
+
+// lockingClause matches the row locking clauses, which PostgreSQL refuses in read-only transactions unless they only lock temporary tables.
+var lockingClause = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|NO\s+KEY\s+UPDATE|SHARE|KEY\s+SHARE)\b`)
+
+// classifyStatement makes a best effort guess whether a statement writes.
+// statementWrite is only returned for statements that PostgreSQL refuses in any read-only transaction, so a statement the server would accept is never rejected.
+// Statements that might or might not write (like WITH, EXPLAIN, or INSERT into what could be a temporary table) are statementUnknown.
+func classifyStatement(sql string) statementKind {
+	sql = skipCommentsAndSpace(sql)
+	sql = strings.TrimLeft(sql, "( \t\r\n")
+	verb := sql
+	if i := strings.IndexAny(sql, " \t\r\n(;"); i != -1 {
+		verb = sql[:i]
+	}
+	switch strings.ToUpper(verb) {
+	case "SELECT":
+		if lockingClause.MatchString(stripLiteralsAndComments(sql)) {
+			return statementUnknown
+		}
+		return statementReadOnly
+	case "VALUES", "TABLE", "SHOW", "SET", "RESET", "DECLARE", "FETCH", "MOVE", "CLOSE", "SAVEPOINT", "RELEASE":
+		return statementReadOnly
+	case "CREATE", "ALTER", "DROP", "TRUNCATE", "GRANT", "REVOKE", "COMMENT", "REFRESH":
+		return statementWrite
+	case "COPY":
+		// COPY (query) TO and COPY table TO only read. COPY table FROM might load into a temporary table.
+		rest := strings.TrimLeft(sql[len(verb):], " \t\r\n")
+		if !strings.HasPrefix(rest, "(") && strings.Contains(strings.ToUpper(stripLiteralsAndComments(rest)), " FROM ") {
+			return statementUnknown
+		}
+		return statementReadOnly
+	}
+	return statementUnknown
+}
+
+// stripLiteralsAndComments replaces string literals, quoted identifiers and comments by a space, so keywords inside them aren't matched.
+func stripLiteralsAndComments(sql string) string {
+	var sb strings.Builder
+	for i := 0; i < len(sql); {
+		end := -1
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			// Doubled quotes are an escaped quote, which this handles as two adjacent literals.
+			if j := strings.IndexByte(sql[i+1:], sql[i]); j != -1 {
+				end = i + 1 + j + 1
+			}
+		case strings.HasPrefix(sql[i:], "--"):
+			end = len(sql)
+			if j := strings.IndexByte(sql[i:], '\n'); j != -1 {
+				end = i + j + 1
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j != -1 {
+				end = i + 2 + j + 2
+			}
+		case sql[i] == '$' && dollarQuoteTag(sql[i:]) != "":
+			tag := dollarQuoteTag(sql[i:])
+			if j := strings.Index(sql[i+len(tag):], tag); j != -1 {
+				end = i + len(tag) + j + len(tag)
+			}
+		default:
+			sb.WriteByte(sql[i])
+			i++
+			continue
+		}
+		if end == -1 {
+			// Unterminated, so the rest of the statement is inside it.
+			end = len(sql)
+		}
+		sb.WriteByte(' ')
+		i = end
+	}
+	return sb.String()
+}
+
+// dollarQuoteTag returns the opening tag (like $$ or $body$) of a dollar-quoted string at the start of s, or "".
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+		case c >= '0' && c <= '9' && i > 1:
+		default:
+			// Not a tag, e.g. a $1 parameter.
+			return ""
+		}
+	}
+	return ""
+}
+
+func skipCommentsAndSpace(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			_, sql = splitLine(sql)
+		case strings.HasPrefix(sql, "/*"):
+			i := strings.Index(sql, "*/")
+			if i == -1 {
+				return ""
+			}
+			sql = sql[i+2:]
+		default:
+			return sql
+		}
+	}
+}
+
+// batchQueries returns the SQL queued in b. pgx doesn't expose it, so this reads
+// the unexported fields and returns nil if their layout is not what it expects.
+func batchQueries(b *pgx.Batch) []string {
+	if b == nil {
+		return nil
+	}
+	items := reflect.ValueOf(b).Elem().FieldByName("items")
+	if items.Kind() != reflect.Slice {
+		return nil
+	}
+	queries := make([]string, 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		item := items.Index(i)
+		if item.Kind() != reflect.Ptr || item.IsNil() {
+			return nil
+		}
+		query := item.Elem().FieldByName("query")
+		if query.Kind() != reflect.String {
+			return nil
+		}
+		queries = append(queries, query.String())
+	}
+	return queries
+}
+
+// checkAccessMode fails statements that write in a read-only transaction, without bothering the server.
+func (t *wrappedTransaction) checkAccessMode(name, sql string) error {
+	if t.txo.AccessMode != pgx.ReadOnly || classifyStatement(sql) != statementWrite {
+		return nil
+	}
+	desc := name
+	if desc == "" {
+		desc, _ = splitLine(skipCommentsAndSpace(sql))
+	}
+	err := fmt.Errorf("%w: %s", ErrReadOnlyTransaction, desc)
+	if t.opts.strict {
+		panic(err)
+	}
+	return err
+}
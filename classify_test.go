@@ -0,0 +1,111 @@
+package trxwrap
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want statementKind
+	}{
+		{"SELECT 1", statementReadOnly},
+		{"  select * from t", statementReadOnly},
+		{"-- name: GetUser :one\nSELECT * FROM users WHERE id = $1", statementReadOnly},
+		{"/* comment */ SELECT 1", statementReadOnly},
+		{"(SELECT 1) UNION (SELECT 2)", statementReadOnly},
+		{"SELECT * FROM t FOR UPDATE", statementUnknown},
+		{"SELECT * FROM t WHERE id = $1 FOR NO KEY UPDATE SKIP LOCKED", statementUnknown},
+		{"select * from t for share", statementUnknown},
+		{"SELECT * FROM t FOR KEY SHARE", statementUnknown},
+		{"SELECT * FROM t\nFOR\tUPDATE", statementUnknown},
+		{"SELECT 'for update' FROM t", statementReadOnly},
+		{"SELECT 'it''s for update' FROM t", statementReadOnly},
+		{`SELECT "for update" FROM t`, statementReadOnly},
+		{"SELECT $$for update$$, $tag$ for share $tag$ FROM t", statementReadOnly},
+		{"SELECT a FROM t -- for update\n", statementReadOnly},
+		{"SELECT a /* for update */ FROM t", statementReadOnly},
+		{"SELECT * FROM information", statementReadOnly},
+		{"VALUES (1), (2)", statementReadOnly},
+		{"SHOW search_path", statementReadOnly},
+		{"FETCH 100 FROM c", statementReadOnly},
+		// Writes to temporary tables are allowed in read-only transactions.
+		{"INSERT INTO t VALUES (1)", statementUnknown},
+		{"-- name: DeleteUser :exec\ndelete from users", statementUnknown},
+		{"UPDATE t SET a = 1", statementUnknown},
+		{"COPY t (a, b) FROM STDIN", statementUnknown},
+		{"COPY t TO STDOUT", statementReadOnly},
+		{"COPY (SELECT a FROM t) TO STDOUT", statementReadOnly},
+		{"TRUNCATE t", statementWrite},
+		{"CREATE TEMPORARY TABLE t (a int)", statementWrite},
+		{"-- name: AddColumn :exec\nALTER TABLE t ADD COLUMN b int", statementWrite},
+		{"DROP TABLE t", statementWrite},
+		{"GRANT SELECT ON t TO u", statementWrite},
+		{"REFRESH MATERIALIZED VIEW v", statementWrite},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", statementUnknown},
+		{"EXPLAIN SELECT 1", statementUnknown},
+		{"", statementUnknown},
+		{"/* unterminated", statementUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyStatement(tt.sql); got != tt.want {
+			t.Errorf("classifyStatement(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"-- name: GetUser :one\nSELECT 1", "GetUser"},
+		{"-- name: ListUsers :many", "ListUsers"},
+		{"-- some comment\n-- name: CreateUser :exec\nINSERT INTO users DEFAULT VALUES", "CreateUser"},
+		{"--name: Tight :one\nSELECT 1", "Tight"},
+		{"-- name:\nSELECT 1", ""},
+		{"SELECT 1", ""},
+		{"SELECT 1 -- name: Trailing :one", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := QueryName(tt.sql); got != tt.want {
+			t.Errorf("QueryName(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestStripLiteralsAndComments(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT 'a', \"b\" FROM t", "SELECT  ,   FROM t"},
+		{"SELECT 'it''s'", "SELECT   "},
+		{"SELECT $1, $$x$$, $q$y$q$", "SELECT $1,  ,  "},
+		{"SELECT a -- c\nFROM t", "SELECT a  FROM t"},
+		{"SELECT /* c */ a", "SELECT   a"},
+		{"SELECT 'unterminated", "SELECT  "},
+	}
+	for _, tt := range tests {
+		if got := stripLiteralsAndComments(tt.sql); got != tt.want {
+			t.Errorf("stripLiteralsAndComments(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestBatchQueries(t *testing.T) {
+	b := &pgx.Batch{}
+	b.Queue("SELECT 1")
+	b.Queue("INSERT INTO t VALUES ($1)", 1)
+	got := batchQueries(b)
+	if len(got) != 2 || got[0] != "SELECT 1" || got[1] != "INSERT INTO t VALUES ($1)" {
+		t.Errorf("batchQueries() = %q", got)
+	}
+	if got := batchQueries(nil); got != nil {
+		t.Errorf("batchQueries(nil) = %q, want nil", got)
+	}
+}
@@ -0,0 +1,83 @@
+package trxwrap
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const defaultCursorBatchSize = 1000
+
+var cursorCounter uint64
+
+// cursorFetchKey marks the context of FETCH and CLOSE statements, which are exempt from WithRepeatBudget.
+type cursorFetchKey struct{}
+
+func isCursorFetch(ctx context.Context) bool {
+	return ctx.Value(cursorFetchKey{}) != nil
+}
+
+// CursorOptions configures StreamCursor.
+type CursorOptions struct {
+	// BatchSize is the number of rows fetched at once. Defaults to 1000.
+	BatchSize int
+}
+
+// StreamCursor runs a query through a server-side cursor and calls fn for each row, without loading the entire result set in memory.
+// Rows are fetched BatchSize at a time. fn should only read the current row, e.g. with Scan or Values.
+// The DECLARE, FETCH and CLOSE statements are named after the query, but only the DECLARE counts towards WithRepeatBudget.
+// ctx must be the context given to the runner by RunTransactionContext and friends.
+//
+// If the transaction is retried, the runner runs again and the rows are streamed from the start, so fn must be prepared to see rows again.
+// To resume after the last row seen instead, use keyset pagination (WHERE key > $1 ORDER BY key) in multiple transactions.
+func StreamCursor(ctx context.Context, sql string, args []interface{}, opts CursorOptions, fn func(pgx.Rows) error) error {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return ErrNoTransaction
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCursorBatchSize
+	}
+	cursor := fmt.Sprintf("trxwrap_cursor_%d", atomic.AddUint64(&cursorCounter, 1))
+	var label string
+	if name := QueryName(sql); name != "" {
+		label = fmt.Sprintf("-- name: %s :cursor\n", name)
+	}
+	declare := fmt.Sprintf("%sDECLARE %s NO SCROLL CURSOR FOR %s", label, cursor, sql)
+	if _, err := wtx.Exec(ctx, declare, args...); err != nil {
+		return err
+	}
+	fetchCtx := context.WithValue(ctx, cursorFetchKey{}, true)
+	fetch := fmt.Sprintf("%sFETCH FORWARD %d FROM %s", label, batchSize, cursor)
+	for {
+		n, err := fetchCursor(fetchCtx, wtx, fetch, fn)
+		if err != nil {
+			return err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+	_, err := wtx.Exec(fetchCtx, label+"CLOSE "+cursor)
+	return err
+}
+
+// fetchCursor fetches one batch of rows and returns how many rows were seen.
+func fetchCursor(ctx context.Context, wtx *wrappedTransaction, fetch string, fn func(pgx.Rows) error) (int, error) {
+	rows, err := wtx.Query(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+		if err := fn(rows); err != nil {
+			return n, err
+		}
+	}
+	return n, rows.Err()
+}
@@ -21,41 +21,100 @@ type PGDBTX interface {
 	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
+	CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error)
+	SendBatch(context.Context, *pgx.Batch) pgx.BatchResults
 }
 
 type TransactionRunner[Q any] func(*Q) error
 
+// ContextRunner is like TransactionRunner, but also receives the context of the attempt.
+// Runners should use this context for their queries and any nested calls into trxwrap.
+type ContextRunner[Q any] func(context.Context, *Q) error
+
 type TrxWrap[Q any] struct {
 	db    PgxHandle
 	gendb func(PGDBTX) *Q
+	opts  options
 }
 
-func New[Q any](db PgxHandle, gendb func(PGDBTX) *Q) TrxWrap[Q] {
-	return TrxWrap[Q]{
+func New[Q any](db PgxHandle, gendb func(PGDBTX) *Q, opts ...Option) TrxWrap[Q] {
+	t := TrxWrap[Q]{
 		db:    db,
 		gendb: gendb,
 	}
+	t.opts.flights = &flightGroup{}
+	for _, o := range opts {
+		o(&t.opts)
+	}
+	if t.opts.groupCommit != nil {
+		t.opts.groupCommitter = newGroupCommitter(db, t.opts)
+	}
+	if t.opts.hedging != nil && len(t.opts.hedging.Replicas) > 0 {
+		t.opts.hedger = newHedger(*t.opts.hedging)
+	}
+	return t
+}
+
+func (w TrxWrap[Q]) RunRWTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q], opts ...RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadWrite,
+	}
+	return w.RunTransaction(ctx, txo, false, runner, opts...)
+}
+
+func (w TrxWrap[Q]) RunROTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q], opts ...RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadOnly,
+	}
+	return w.RunTransaction(ctx, txo, true, runner, opts...)
 }
 
-func (w TrxWrap[Q]) RunRWTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q]) error {
+func (w TrxWrap[Q]) RunRWTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner ContextRunner[Q], opts ...RunOption) error {
 	txo := pgx.TxOptions{
 		IsoLevel:   isolationLevel,
 		AccessMode: pgx.ReadWrite,
 	}
-	return w.RunTransaction(ctx, txo, false, runner)
+	return w.RunTransactionContext(ctx, txo, false, runner, opts...)
 }
 
-func (w TrxWrap[Q]) RunROTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q]) error {
+func (w TrxWrap[Q]) RunROTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner ContextRunner[Q], opts ...RunOption) error {
 	txo := pgx.TxOptions{
 		IsoLevel:   isolationLevel,
 		AccessMode: pgx.ReadOnly,
 	}
-	return w.RunTransaction(ctx, txo, true, runner)
+	return w.RunTransactionContext(ctx, txo, true, runner, opts...)
+}
+
+func (t TrxWrap[Q]) RunTransaction(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner TransactionRunner[Q], opts ...RunOption) error {
+	return t.runTransaction(ctx, txo, idempotent, func(_ context.Context, q *Q) error {
+		return runner(q)
+	}, runner, opts)
+}
+
+func (t TrxWrap[Q]) RunTransactionContext(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner ContextRunner[Q], opts ...RunOption) error {
+	return t.runTransaction(ctx, txo, idempotent, runner, runner, opts)
 }
 
-func (t TrxWrap[Q]) RunTransaction(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner TransactionRunner[Q]) error {
+// runTransaction runs the runner with retries. origRunner is the function given to us by the user, used for diagnostics.
+func (t TrxWrap[Q]) runTransaction(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner ContextRunner[Q], origRunner interface{}, opts []RunOption) error {
+	pcs := callerPCs()
+	var ro runOptions
+	for _, o := range opts {
+		o(&ro)
+	}
+	if outer := activeTransaction(ctx); outer != nil {
+		join, err := t.handleNested(ctx, outer, txo, ro.dryRun != nil, pcs)
+		if err != nil {
+			return err
+		}
+		if join {
+			return runner(ctx, t.gendb(outer))
+		}
+	}
 	return retry(ctx, func() (bool, error) {
-		return t.runTransactionOnce(ctx, txo, runner)
+		return t.runTransactionOnce(ctx, txo, runner, origRunner, pcs, &ro)
 	}, idempotent || txo.AccessMode == pgx.ReadOnly)
 }
 
@@ -89,17 +148,34 @@ func retry(ctx context.Context, f func() (bool, error), idempotent bool) error {
 	}
 }
 
-func (t TrxWrap[Q]) runTransactionOnce(ctx context.Context, txo pgx.TxOptions, runner TransactionRunner[Q]) (commitAttempted bool, _ error) {
+func (t TrxWrap[Q]) runTransactionOnce(ctx context.Context, txo pgx.TxOptions, runner ContextRunner[Q], origRunner interface{}, pcs []uintptr, ro *runOptions) (commitAttempted bool, _ error) {
 	tx, err := t.db.BeginTx(ctx, txo)
 	if err != nil {
 		return false, wrapError(err)
 	}
-	q := t.gendb(wrappedTransaction{tx})
-	if err := runner(q); err != nil {
+	wtx := newWrappedTransaction(tx, txo, &t.opts, ro, origRunner, pcs)
+	q := t.gendb(wtx)
+	actx, stop := t.startWatchdog(withActiveTransaction(ctx, wtx), wtx)
+	defer stop()
+	if err := t.callRunner(actx, wtx, runner, q); err != nil {
+		if t.opts.lockDiagnostics {
+			t.collectLockDiagnostics(wtx, err)
+		}
+		if ro.dryRun != nil {
+			ro.dryRun.Statements = wtx.loggedStatements()
+		}
 		// TODO: Use multi-error to combine a possible error from rollback with err.
 		tx.Rollback(ctx)
 		return false, err
 	}
+	if err := wtx.abortError(); err != nil {
+		// The runner ignored the error it got after we aborted the transaction.
+		tx.Rollback(ctx)
+		return false, err
+	}
+	if ro.dryRun != nil {
+		return false, t.finishDryRun(ctx, wtx, ro.dryRun)
+	}
 	return true, wrapError(tx.Commit(ctx))
 }
 
@@ -126,11 +202,28 @@ func wrapError(err error) error {
 	if err == pgx.ErrNoRows || err == pgx.ErrTxClosed || err == pgx.ErrTxCommitRollback {
 		return err
 	}
-	return Error{err}
+	e := Error{parent: err}
+	switch ToSQLState(err) {
+	case "40P01", "55P03":
+		e.lock = &lockDiagnosticsSlot{}
+	}
+	return e
+}
+
+// wrapQueryError is like wrapError, but also remembers which query failed.
+func wrapQueryError(err error, name string) error {
+	err = wrapError(err)
+	if e, ok := err.(Error); ok {
+		e.query = name
+		return e
+	}
+	return err
 }
 
 type Error struct {
 	parent error
+	lock   *lockDiagnosticsSlot
+	query  string
 }
 
 func (e Error) Error() string {
@@ -145,9 +238,47 @@ func (e Error) Unwrap() error {
 	return e.parent
 }
 
+// QueryName returns the sqlc name of the query that failed, if known.
+func (e Error) QueryName() string {
+	return e.query
+}
+
 func isReadOnlyQuery(sql string) bool {
+	return classifyStatement(sql) == statementReadOnly
+}
+
+// QueryName returns the name sqlc gave to a query (from the "-- name: GetStudents :many" comment), or "" if there is none.
+func QueryName(sql string) string {
 	for strings.HasPrefix(sql, "--") {
-		sql = sql[strings.Index(sql, "\n")+1:]
+		var line string
+		line, sql = splitLine(sql)
+		if f := strings.Fields(line[2:]); len(f) >= 2 && f[0] == "name:" {
+			return f[1]
+		}
+	}
+	return ""
+}
+
+func splitLine(s string) (string, string) {
+	i := strings.IndexByte(s, '\n')
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+type queryNameKey struct{}
+
+// QueryNameFromContext returns the sqlc name of the query being executed.
+// The context passed to the underlying pgx.Tx carries this, so pgx loggers and tracers can use it to label queries.
+func QueryNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(queryNameKey{}).(string)
+	return name
+}
+
+func withQueryName(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
 	}
-	return strings.HasPrefix(sql, "SELECT")
+	return context.WithValue(ctx, queryNameKey{}, name)
 }
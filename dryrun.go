@@ -0,0 +1,62 @@
+package trxwrap
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// DryRunResult describes what a runner did during RunDryRun.
+type DryRunResult struct {
+	// Statements are the statements executed by the last attempt, in order.
+	Statements []DryRunStatement
+}
+
+// DryRunStatement is a statement executed during a dry run.
+type DryRunStatement struct {
+	Op QueryOp
+	// Name is the sqlc name of the query, if any.
+	Name string
+	SQL  string
+	// RowsAffected is taken from the CommandTag, or the number of rows copied for CopyFrom.
+	RowsAffected int64
+	Err          error
+}
+
+// RunDryRun runs the runner like RunTransaction, but always rolls back the transaction instead of committing it.
+// Before rolling back, deferred constraints are checked with SET CONSTRAINTS ALL IMMEDIATE, so their violations are returned too.
+// As nothing is committed, the runner is retried like an idempotent transaction.
+func (t TrxWrap[Q]) RunDryRun(ctx context.Context, txo pgx.TxOptions, runner TransactionRunner[Q], opts ...RunOption) (DryRunResult, error) {
+	var res DryRunResult
+	opts = append(opts[:len(opts):len(opts)], func(o *runOptions) {
+		o.dryRun = &res
+	})
+	err := t.RunTransaction(ctx, txo, true, runner, opts...)
+	return res, err
+}
+
+// finishDryRun checks deferred constraints and rolls back the transaction.
+func (t TrxWrap[Q]) finishDryRun(ctx context.Context, wtx *wrappedTransaction, res *DryRunResult) error {
+	_, err := wtx.tx.Exec(ctx, "SET CONSTRAINTS ALL IMMEDIATE")
+	res.Statements = wtx.loggedStatements()
+	wtx.tx.Rollback(ctx)
+	return wrapError(err)
+}
+
+func (t *wrappedTransaction) recordStatement(e *QueryEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.log = append(t.log, DryRunStatement{
+		Op:           e.Op,
+		Name:         e.Name,
+		SQL:          e.SQL,
+		RowsAffected: e.RowsAffected,
+		Err:          e.Err,
+	})
+}
+
+func (t *wrappedTransaction) loggedStatements() []DryRunStatement {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.log
+}
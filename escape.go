@@ -0,0 +1,95 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrNoTransaction is returned by UnderlyingTx if the context doesn't belong to a runner.
+var ErrNoTransaction = errors.New("trxwrap: no transaction in context")
+
+// Tx is the transaction of a runner, as returned by UnderlyingTx. It has the methods of pgx.Tx that are safe to use within a runner.
+// Commit and Rollback are left out as trxwrap owns them, Begin is left out as savepoints can be made with Exec,
+// and LargeObjects is left out in favor of CreateLargeObject and friends.
+type Tx interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	QueryFunc(ctx context.Context, sql string, args []interface{}, scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error)
+	// Conn returns the underlying connection, e.g. for Conn().PgConn(). Anything done through it bypasses trxwrap.
+	Conn() *pgx.Conn
+}
+
+// UnderlyingTx returns the transaction of the runner that was given ctx by RunTransactionContext and friends.
+// This gives access to pgx features sqlc doesn't use, like Prepare.
+// Errors are wrapped like those returned through *Q.
+func UnderlyingTx(ctx context.Context) (Tx, error) {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return nil, ErrNoTransaction
+	}
+	return escapedTx{wtx}, nil
+}
+
+type escapedTx struct {
+	w *wrappedTransaction
+}
+
+func (t escapedTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return t.w.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (t escapedTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return t.w.SendBatch(ctx, b)
+}
+
+func (t escapedTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	if err := t.w.acquire(QueryName(sql)); err != nil {
+		return nil, err
+	}
+	defer t.w.release()
+	sd, err := t.w.tx.Prepare(ctx, name, sql)
+	return sd, wrapError(err)
+}
+
+func (t escapedTx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return t.w.Exec(ctx, sql, arguments...)
+}
+
+func (t escapedTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return t.w.Query(ctx, sql, args...)
+}
+
+func (t escapedTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return t.w.QueryRow(ctx, sql, args...)
+}
+
+func (t escapedTx) QueryFunc(ctx context.Context, sql string, args []interface{}, scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	rows, err := t.w.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(scans...); err != nil {
+			return nil, err
+		}
+		if err := f(rows); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rows.CommandTag(), nil
+}
+
+func (t escapedTx) Conn() *pgx.Conn {
+	return t.w.tx.Conn()
+}
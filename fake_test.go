@@ -0,0 +1,136 @@
+package trxwrap
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeHandle is a PgxHandle that hands out fakeTxs, so tests don't need a database.
+type fakeHandle struct {
+	// execErr, if set, is called for every statement. A non-nil error fails the statement.
+	execErr func(sql string) error
+
+	mu  sync.Mutex
+	txs []*fakeTx
+}
+
+func (h *fakeHandle) BeginTx(ctx context.Context, txo pgx.TxOptions) (pgx.Tx, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tx := &fakeTx{txo: txo, execErr: h.execErr}
+	h.txs = append(h.txs, tx)
+	return tx, nil
+}
+
+func (h *fakeHandle) transactions() []*fakeTx {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*fakeTx(nil), h.txs...)
+}
+
+// fakeTx records the statements executed on it. Methods that aren't implemented panic through the nil pgx.Tx.
+type fakeTx struct {
+	pgx.Tx
+	txo     pgx.TxOptions
+	execErr func(sql string) error
+
+	mu         sync.Mutex
+	statements []string
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) exec(sql string) error {
+	tx.mu.Lock()
+	tx.statements = append(tx.statements, sql)
+	tx.mu.Unlock()
+	if tx.execErr != nil {
+		return tx.execErr(sql)
+	}
+	return nil
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if err := tx.exec(sql); err != nil {
+		return nil, err
+	}
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if err := tx.exec(sql); err != nil {
+		return nil, err
+	}
+	return &fakeRows{n: 2}, nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.committed || tx.rolledBack {
+		return pgx.ErrTxClosed
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.committed || tx.rolledBack {
+		return pgx.ErrTxClosed
+	}
+	tx.rolledBack = true
+	return nil
+}
+
+func (tx *fakeTx) outcome() (committed, rolledBack bool) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.committed, tx.rolledBack
+}
+
+// countStatements returns how many statements started with prefix.
+func (tx *fakeTx) countStatements(prefix string) int {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	n := 0
+	for _, s := range tx.statements {
+		if strings.HasPrefix(s, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeRows returns n rows without any columns.
+type fakeRows struct {
+	pgx.Rows
+	n      int
+	closed bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.n == 0 {
+		r.closed = true
+		return false
+	}
+	r.n--
+	return true
+}
+
+func (r *fakeRows) Close() {
+	r.closed = true
+}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+func (r *fakeRows) CommandTag() pgconn.CommandTag {
+	return pgconn.CommandTag("SELECT 2")
+}
@@ -0,0 +1,201 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// WithGroupCommit enables group commit for RunGroupedRWTransaction.
+// Runners submitted within window of each other (up to maxBatch of them) are executed in a single transaction with the given isolation level, each in its own savepoint.
+// This saves a commit (and its fsync) per runner, at the cost of latency.
+func WithGroupCommit(window time.Duration, maxBatch int, isolationLevel pgx.TxIsoLevel) Option {
+	return func(o *options) {
+		o.groupCommit = &groupCommitConfig{
+			window:         window,
+			maxBatch:       maxBatch,
+			isolationLevel: isolationLevel,
+		}
+	}
+}
+
+type groupCommitConfig struct {
+	window         time.Duration
+	maxBatch       int
+	isolationLevel pgx.TxIsoLevel
+}
+
+// RunGroupedRWTransaction runs a small read-write runner, possibly in the same transaction as other concurrent runners (see WithGroupCommit).
+// If the runner fails, only its own changes are rolled back. If the shared transaction fails to commit, all runners get that error.
+// The runner can be retried (together with the others) like with RunRWTransaction.
+//
+// The runner must use the context it's given rather than ctx: it has the values of ctx, but is only canceled when the shared transaction is.
+// Canceling ctx doesn't affect the other runners. It's only checked before the runner starts; once it has started, this waits for the outcome of the shared transaction.
+// Without WithGroupCommit, this is a regular RunRWTransactionContext with isolation level Read Committed.
+func (t TrxWrap[Q]) RunGroupedRWTransaction(ctx context.Context, runner ContextRunner[Q]) error {
+	if t.opts.groupCommitter == nil {
+		return t.RunRWTransactionContext(ctx, pgx.ReadCommitted, runner)
+	}
+	s := &groupSubmission{
+		ctx: ctx,
+		run: func(ctx context.Context, db PGDBTX) error {
+			return runner(ctx, t.gendb(db))
+		},
+		done: make(chan struct{}),
+	}
+	t.opts.groupCommitter.submit(s)
+	<-s.done
+	if s.panic != nil {
+		panic(s.panic)
+	}
+	return s.err
+}
+
+// errGroupAborted is returned to all runners of a batch if one of them called runtime.Goexit.
+var errGroupAborted = errors.New("trxwrap: group commit transaction aborted by runtime.Goexit")
+
+type groupSubmission struct {
+	ctx   context.Context
+	run   func(context.Context, PGDBTX) error
+	done  chan struct{}
+	err   error
+	panic interface{}
+}
+
+type groupCommitter struct {
+	cfg groupCommitConfig
+	raw TrxWrap[Raw]
+
+	mu      sync.Mutex
+	pending []*groupSubmission
+	timer   *time.Timer
+}
+
+func newGroupCommitter(db PgxHandle, opts options) *groupCommitter {
+	cfg := *opts.groupCommit
+	opts.groupCommit = nil
+	opts.groupCommitter = nil
+	return &groupCommitter{
+		cfg: cfg,
+		raw: TrxWrap[Raw]{
+			db: db,
+			gendb: func(tx PGDBTX) *Raw {
+				return &Raw{DB: tx}
+			},
+			opts: opts,
+		},
+	}
+}
+
+func (g *groupCommitter) submit(s *groupSubmission) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending = append(g.pending, s)
+	if g.cfg.maxBatch > 0 && len(g.pending) >= g.cfg.maxBatch {
+		g.flushLocked()
+		return
+	}
+	if g.timer == nil {
+		g.timer = time.AfterFunc(g.cfg.window, func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.flushLocked()
+		})
+	}
+}
+
+func (g *groupCommitter) flushLocked() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if len(g.pending) == 0 {
+		return
+	}
+	batch := g.pending
+	g.pending = nil
+	go g.execute(batch)
+}
+
+// execute runs a batch of submissions in one transaction and delivers their results.
+func (g *groupCommitter) execute(batch []*groupSubmission) {
+	// err is only left at errGroupAborted if a runner calls runtime.Goexit, which still runs this defer.
+	err := errGroupAborted
+	defer func() {
+		for _, s := range batch {
+			if err != nil && s.err == nil && s.panic == nil {
+				s.err = err
+			}
+			close(s.done)
+		}
+	}()
+	err = g.raw.RunRWTransactionContext(context.Background(), g.cfg.isolationLevel, func(ctx context.Context, r *Raw) error {
+		for _, s := range batch {
+			s.err = nil
+			s.panic = nil
+		}
+		for i, s := range batch {
+			if err := s.ctx.Err(); err != nil {
+				s.err = err
+				continue
+			}
+			if err := g.runSubmission(ctx, r.DB, i, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runSubmission runs a single runner in a savepoint. The returned error aborts the whole batch.
+func (g *groupCommitter) runSubmission(ctx context.Context, db PGDBTX, i int, s *groupSubmission) error {
+	savepoint := fmt.Sprintf("trxwrap_group_%d", i)
+	if _, err := db.Exec(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+	func() {
+		returned := false
+		defer func() {
+			if returned {
+				return
+			}
+			if r := recover(); r != nil {
+				s.panic = r
+				return
+			}
+			// Either runtime.Goexit() or panic(nil). In the former case, execute delivers errGroupAborted to the others.
+			s.err = &PanicError{Stack: debug.Stack()}
+		}()
+		s.err = s.run(groupContext{ctx, s.ctx}, db)
+		returned = true
+	}()
+	switch ToSQLState(s.err) {
+	case "40001", "40P01":
+		// Retry the entire batch, like RunRWTransaction would have.
+		return s.err
+	}
+	if s.err != nil || s.panic != nil {
+		_, err := db.Exec(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+		return err
+	}
+	_, err := db.Exec(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+// groupContext is the context of the shared transaction, with the values of the submitter's context too.
+type groupContext struct {
+	context.Context
+	submitter context.Context
+}
+
+func (c groupContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.submitter.Value(key)
+}
@@ -0,0 +1,117 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// runGrouped runs the runners concurrently with RunGroupedRWTransaction and returns their errors.
+func runGrouped(t *testing.T, db TrxWrap[Raw], runners ...ContextRunner[Raw]) []error {
+	t.Helper()
+	errs := make([]error, len(runners))
+	var wg sync.WaitGroup
+	for i, r := range runners {
+		wg.Add(1)
+		go func(i int, r ContextRunner[Raw]) {
+			defer wg.Done()
+			errs[i] = db.RunGroupedRWTransaction(context.Background(), r)
+		}(i, r)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunGroupedRWTransaction didn't return")
+	}
+	return errs
+}
+
+func insertRunner(ctx context.Context, r *Raw) error {
+	_, err := r.DB.Exec(ctx, "INSERT INTO t VALUES (1)")
+	return err
+}
+
+func TestGroupCommitFlushesAtMaxBatch(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h, WithGroupCommit(time.Hour, 3, pgx.ReadCommitted))
+	for i, err := range runGrouped(t, db, insertRunner, insertRunner, insertRunner) {
+		if err != nil {
+			t.Errorf("runner %d failed: %v", i, err)
+		}
+	}
+	txs := h.transactions()
+	if len(txs) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(txs))
+	}
+	if committed, _ := txs[0].outcome(); !committed {
+		t.Error("shared transaction wasn't committed")
+	}
+	if n := txs[0].countStatements("RELEASE SAVEPOINT"); n != 3 {
+		t.Errorf("released %d savepoints, want 3", n)
+	}
+}
+
+func TestGroupCommitFlushesAfterWindow(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h, WithGroupCommit(10*time.Millisecond, 100, pgx.ReadCommitted))
+	if err := runGrouped(t, db, insertRunner)[0]; err != nil {
+		t.Errorf("runner failed: %v", err)
+	}
+	if txs := h.transactions(); len(txs) != 1 || txs[0].countStatements("INSERT") != 1 {
+		t.Errorf("want the runner to run in one transaction")
+	}
+}
+
+func TestGroupCommitFailingRunnerOnlyRollsBackItself(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h, WithGroupCommit(time.Hour, 3, pgx.ReadCommitted))
+	runnerErr := errors.New("runner failed")
+	errs := runGrouped(t, db, insertRunner, func(ctx context.Context, r *Raw) error {
+		if err := insertRunner(ctx, r); err != nil {
+			return err
+		}
+		return runnerErr
+	}, insertRunner)
+	if errs[0] != nil || errs[1] != runnerErr || errs[2] != nil {
+		t.Errorf("got errors %v, want only the second runner to fail", errs)
+	}
+	tx := h.transactions()[0]
+	if committed, _ := tx.outcome(); !committed {
+		t.Error("shared transaction wasn't committed")
+	}
+	if n := tx.countStatements("ROLLBACK TO SAVEPOINT"); n != 1 {
+		t.Errorf("rolled back to %d savepoints, want 1", n)
+	}
+	if n := tx.countStatements("RELEASE SAVEPOINT"); n != 2 {
+		t.Errorf("released %d savepoints, want 2", n)
+	}
+}
+
+func TestGroupCommitGoexit(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h, WithGroupCommit(time.Hour, 2, pgx.ReadCommitted))
+	errs := runGrouped(t, db, insertRunner, func(ctx context.Context, r *Raw) error {
+		runtime.Goexit()
+		return nil
+	})
+	if errs[0] != errGroupAborted {
+		t.Errorf("other runner: got %v, want errGroupAborted", errs[0])
+	}
+	var pe *PanicError
+	if !errors.As(errs[1], &pe) {
+		t.Errorf("exiting runner: got %v, want a *PanicError", errs[1])
+	}
+	if committed, rolledBack := h.transactions()[0].outcome(); committed || !rolledBack {
+		t.Errorf("committed=%v, rolledBack=%v; want a rollback", committed, rolledBack)
+	}
+}
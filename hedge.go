@@ -0,0 +1,187 @@
+package trxwrap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// hedgeSamples is the number of recent latencies the hedging delay is based on.
+const hedgeSamples = 1000
+
+// Hedging configures RunHedgedROTransaction.
+type Hedging struct {
+	// Replicas are the handles hedged transactions are sent to, in round-robin order.
+	Replicas []PgxHandle
+	// Percentile of recent latencies after which the transaction is hedged, e.g. 0.95. It must be between 0 and 1.
+	Percentile float64
+	// MinDelay and MaxDelay bound the hedging delay. MaxDelay is also used until enough latencies are known, and must be positive.
+	MinDelay, MaxDelay time.Duration
+}
+
+// WithHedging enables hedging for RunHedgedROTransaction. It panics if h is invalid.
+func WithHedging(h Hedging) Option {
+	if h.Percentile < 0 || h.Percentile > 1 {
+		panic(fmt.Sprintf("trxwrap: Hedging.Percentile must be between 0 and 1, got %v", h.Percentile))
+	}
+	if h.MaxDelay <= 0 {
+		panic(fmt.Sprintf("trxwrap: Hedging.MaxDelay must be positive, got %v", h.MaxDelay))
+	}
+	if h.MinDelay > h.MaxDelay {
+		panic(fmt.Sprintf("trxwrap: Hedging.MinDelay (%v) must not exceed MaxDelay (%v)", h.MinDelay, h.MaxDelay))
+	}
+	return func(o *options) {
+		o.hedging = &h
+	}
+}
+
+// RunHedgedROTransaction runs a read-only transaction like RunROTransactionContext.
+// If it hasn't finished after the configured percentile of recent latencies, the runner is started again on one of the replicas given to WithHedging.
+// The first successful result is returned and the other attempt is canceled through its context, so the runner must use the context it's given.
+// Only the result of the winning attempt is returned, so the runner must not have any other side effects. If all attempts fail, the first error is returned.
+// Without WithHedging, this doesn't hedge. Calling this from within a runner fails with ErrNestedTransaction.
+func RunHedgedROTransaction[Q, T any](ctx context.Context, t TrxWrap[Q], isolationLevel pgx.TxIsoLevel, runner func(context.Context, *Q) (T, error)) (T, error) {
+	if outer := activeTransaction(ctx); outer != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: can't hedge from within the transaction started at %s", ErrNestedTransaction, describeCaller(outer.pcs))
+	}
+	type result struct {
+		val     T
+		err     error
+		panic   interface{}
+		primary bool
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// hedgeWon is set to 1 before the primary is canceled because the hedged attempt succeeded.
+	var hedgeWon int32
+	results := make(chan result, 2)
+	start := func(w TrxWrap[Q], primary bool) {
+		go func() {
+			res := result{primary: primary}
+			defer func() {
+				if r := recover(); r != nil {
+					res.panic = r
+				}
+				results <- res
+			}()
+			started := time.Now()
+			res.err = w.RunROTransactionContext(ctx, isolationLevel, func(ctx context.Context, q *Q) error {
+				var err error
+				res.val, err = runner(ctx, q)
+				return err
+			})
+			// Only the primary's latency is recorded, as hedged attempts are only started for slow transactions.
+			// If it was canceled because the hedged attempt won, its latency is at least what it took so far.
+			// Cancellation by the caller says nothing about its latency, so that isn't recorded.
+			if primary && t.opts.hedger != nil && (res.err == nil || atomic.LoadInt32(&hedgeWon) == 1) {
+				t.opts.hedger.record(time.Since(started))
+			}
+		}()
+	}
+	start(t, true)
+	running := 1
+	var hedge <-chan time.Time
+	if t.opts.hedger != nil {
+		timer := time.NewTimer(t.opts.hedger.delay())
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	var firstErr error
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			h := t
+			h.db = t.opts.hedger.nextReplica()
+			start(h, false)
+			running++
+		case res := <-results:
+			running--
+			if res.panic != nil {
+				panic(res.panic)
+			}
+			if res.err == nil {
+				if !res.primary {
+					atomic.StoreInt32(&hedgeWon, 1)
+				}
+				return res.val, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if running == 0 {
+				return res.val, firstErr
+			}
+		}
+	}
+}
+
+// hedger keeps track of recent latencies to decide when to hedge.
+type hedger struct {
+	cfg Hedging
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	next     int
+	recorded int
+	cached   time.Duration
+	replica  int
+}
+
+func newHedger(cfg Hedging) *hedger {
+	return &hedger{
+		cfg:    cfg,
+		cached: cfg.MaxDelay,
+	}
+}
+
+func (h *hedger) record(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	h.recorded++
+	// Sorting is relatively expensive, so only update the delay every so often.
+	if h.recorded%100 == 0 {
+		h.cached = h.computeDelay()
+	}
+}
+
+func (h *hedger) computeDelay() time.Duration {
+	sorted := append([]time.Duration(nil), h.samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	d := sorted[int(h.cfg.Percentile*float64(len(sorted)-1))]
+	if d < h.cfg.MinDelay {
+		d = h.cfg.MinDelay
+	}
+	if d > h.cfg.MaxDelay {
+		d = h.cfg.MaxDelay
+	}
+	return d
+}
+
+func (h *hedger) delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cached
+}
+
+func (h *hedger) nextReplica() PgxHandle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.cfg.Replicas[h.replica%len(h.cfg.Replicas)]
+	h.replica++
+	return r
+}
@@ -0,0 +1,22 @@
+package trxwrap
+
+import (
+	"context"
+)
+
+// Hooks are callbacks invoked by TrxWrap on notable events. Any of them can be nil.
+// Hooks are called synchronously and should return quickly.
+type Hooks struct {
+	// OnPanic is called when a runner panicked, after its transaction was rolled back.
+	OnPanic func(ctx context.Context, value interface{}, stack []byte)
+
+	// OnNestedTransaction is called when a transaction is started while another one is active in the same call chain.
+	// outer and inner are the locations where the transactions were started.
+	OnNestedTransaction func(ctx context.Context, outer, inner string)
+
+	// OnLongTransaction is called when a transaction exceeds the duration configured with WithMaxTransactionDuration.
+	OnLongTransaction func(ctx context.Context, e LongTransactionEvent)
+
+	// OnBudgetExceeded is called when a transaction exceeds the budgets given with WithStatementBudget or WithRepeatBudget.
+	OnBudgetExceeded func(ctx context.Context, err *BudgetExceededError)
+}
@@ -0,0 +1,137 @@
+package trxwrap
+
+import (
+	"context"
+	"io"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// largeObjectChunkSize is the maximum number of bytes sent or requested in a single call.
+const largeObjectChunkSize = 1 << 20
+
+// Large objects can be streamed within a runner given a context by RunTransactionContext and friends.
+// Like everything else in the transaction, a large object that was partially written is discarded if the transaction is rolled back, e.g. to be retried.
+// Note that the runner then runs again, so the data it streams must be available again too.
+// Readers and writers fail once the runner returned.
+
+// CreateLargeObject creates a new large object with the contents of r and returns its OID.
+func CreateLargeObject(ctx context.Context, r io.Reader) (uint32, error) {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return 0, ErrNoTransaction
+	}
+	if err := wtx.acquire(""); err != nil {
+		return 0, err
+	}
+	los := wtx.tx.LargeObjects()
+	oid, err := los.Create(ctx, 0)
+	wtx.release()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	w, err := openLargeObject(ctx, wtx, oid, pgx.LargeObjectModeWrite)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return 0, err
+	}
+	return oid, w.Close()
+}
+
+// OpenLargeObjectReader opens a large object for reading.
+func OpenLargeObjectReader(ctx context.Context, oid uint32) (io.ReadCloser, error) {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return nil, ErrNoTransaction
+	}
+	return openLargeObject(ctx, wtx, oid, pgx.LargeObjectModeRead)
+}
+
+// OpenLargeObjectWriter opens a large object for writing. Writes start at the beginning, overwriting existing data.
+func OpenLargeObjectWriter(ctx context.Context, oid uint32) (io.WriteCloser, error) {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return nil, ErrNoTransaction
+	}
+	return openLargeObject(ctx, wtx, oid, pgx.LargeObjectModeWrite)
+}
+
+// UnlinkLargeObject deletes a large object.
+func UnlinkLargeObject(ctx context.Context, oid uint32) error {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return ErrNoTransaction
+	}
+	if err := wtx.acquire(""); err != nil {
+		return err
+	}
+	defer wtx.release()
+	los := wtx.tx.LargeObjects()
+	return wrapError(los.Unlink(ctx, oid))
+}
+
+func openLargeObject(ctx context.Context, wtx *wrappedTransaction, oid uint32, mode pgx.LargeObjectMode) (*largeObject, error) {
+	if err := wtx.acquire(""); err != nil {
+		return nil, err
+	}
+	defer wtx.release()
+	los := wtx.tx.LargeObjects()
+	lo, err := los.Open(ctx, oid, mode)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &largeObject{wtx: wtx, lo: lo}, nil
+}
+
+// largeObject guards a pgx.LargeObject against concurrent use and wraps its errors.
+type largeObject struct {
+	wtx *wrappedTransaction
+	lo  *pgx.LargeObject
+}
+
+func (o *largeObject) Read(p []byte) (int, error) {
+	if len(p) > largeObjectChunkSize {
+		p = p[:largeObjectChunkSize]
+	}
+	if err := o.wtx.acquire(""); err != nil {
+		return 0, err
+	}
+	defer o.wtx.release()
+	n, err := o.lo.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, wrapError(err)
+}
+
+func (o *largeObject) Write(p []byte) (int, error) {
+	if err := o.wtx.acquire(""); err != nil {
+		return 0, err
+	}
+	defer o.wtx.release()
+	var written int
+	for len(p) > 0 {
+		c := p
+		if len(c) > largeObjectChunkSize {
+			c = c[:largeObjectChunkSize]
+		}
+		n, err := o.lo.Write(c)
+		written += n
+		if err != nil {
+			return written, wrapError(err)
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (o *largeObject) Close() error {
+	if err := o.wtx.acquire(""); err != nil {
+		return err
+	}
+	defer o.wtx.release()
+	return wrapError(o.lo.Close())
+}
@@ -0,0 +1,78 @@
+package trxwrap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrTransactionFinished is returned when a *Q is used after its runner returned.
+// The actual returned error is a *TransactionFinishedError, which matches ErrTransactionFinished with errors.Is.
+var ErrTransactionFinished = errors.New("trxwrap: transaction already finished")
+
+// TransactionFinishedError is returned when a *Q is used after its runner returned, typically because it leaked into a goroutine.
+type TransactionFinishedError struct {
+	// StartedAt is the location of the code that started the transaction.
+	StartedAt string
+	// Runner is the location of the runner that was given the *Q.
+	Runner string
+	// EndedAt is when the runner returned.
+	EndedAt time.Time
+	// Outcome describes how the runner ended.
+	Outcome string
+}
+
+func (e *TransactionFinishedError) Error() string {
+	return fmt.Sprintf("%v: started at %s, runner %s %s at %s", ErrTransactionFinished, e.StartedAt, e.Runner, e.Outcome, e.EndedAt.Format(time.RFC3339Nano))
+}
+
+func (e *TransactionFinishedError) Is(target error) bool {
+	return target == ErrTransactionFinished
+}
+
+func (e *TransactionFinishedError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, "database error")
+}
+
+// WithStrictMode makes misuse of transactions panic rather than return an error. This is intended for tests.
+func WithStrictMode() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// callerPCs returns the program counters of the stack of our caller.
+func callerPCs() []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+// describeCaller returns the location of the first frame outside this package.
+func describeCaller(pcs []uintptr) string {
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "github.com/Jille/trxwrap.") {
+			return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// describeFunc returns the location of the given function.
+func describeFunc(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	file, line := f.FileLine(f.Entry())
+	return fmt.Sprintf("%s (%s:%d)", f.Name(), file, line)
+}
@@ -0,0 +1,50 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestUseAfterRunnerReturned(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h)
+	var leaked *Raw
+	if err := db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+		leaked = r
+		return nil
+	}); err != nil {
+		t.Fatalf("RunRWTransaction failed: %v", err)
+	}
+	_, err := leaked.DB.Exec(context.Background(), "SELECT 1")
+	if !errors.Is(err, ErrTransactionFinished) {
+		t.Fatalf("Exec after the runner returned: got %v, want ErrTransactionFinished", err)
+	}
+	var fe *TransactionFinishedError
+	if !errors.As(err, &fe) || fe.Outcome != "returned" || fe.EndedAt.IsZero() {
+		t.Errorf("got %#v, want a TransactionFinishedError with outcome returned", err)
+	}
+	if n := h.transactions()[0].countStatements(""); n != 0 {
+		t.Errorf("%d statements reached the transaction, want 0", n)
+	}
+}
+
+func TestUseAfterRunnerReturnedStrict(t *testing.T) {
+	db := NewRaw(&fakeHandle{}, WithStrictMode())
+	var leaked *Raw
+	db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+		leaked = r
+		return errors.New("runner failed")
+	})
+	defer func() {
+		err, _ := recover().(error)
+		var fe *TransactionFinishedError
+		if !errors.As(err, &fe) || fe.Outcome != "returned an error" {
+			t.Errorf("got panic %v, want a TransactionFinishedError with outcome \"returned an error\"", err)
+		}
+	}()
+	leaked.DB.Query(context.Background(), "SELECT 1")
+	t.Error("Query after the runner returned didn't panic in strict mode")
+}
@@ -0,0 +1,137 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// lockDiagnosticsTimeout is how long we'll spend on gathering lock diagnostics. The failed transaction keeps its locks meanwhile, so this is short.
+const lockDiagnosticsTimeout = 500 * time.Millisecond
+
+// WithLockDiagnostics enables gathering diagnostics when a transaction fails with a deadlock (40P01) or lock timeout (55P03).
+// For lock timeouts, a separate connection is used to find which transactions might be holding the locks.
+// This is best-effort: by then the lock we waited for is no longer in pg_locks, so we can only list transactions holding locks that conflict with writes (ROW EXCLUSIVE) on relations our transaction touched.
+// Holders of row locks can't be found this way.
+// The diagnostics can be retrieved with Error.LockDiagnostics.
+func WithLockDiagnostics() Option {
+	return func(o *options) {
+		o.lockDiagnostics = true
+	}
+}
+
+// LockDiagnostics describes why a transaction failed to acquire a lock.
+type LockDiagnostics struct {
+	// PID is the backend process ID of the failed transaction.
+	PID uint32
+	// Detail is the detail message of the error. For deadlocks this describes the processes involved.
+	Detail string
+	// Blockers are the transactions holding locks that conflict with writes on the relations touched by the failed transaction, oldest first.
+	// This is only filled in for lock timeouts.
+	Blockers []LockHolder
+	// BlockersError is set if finding the blockers failed.
+	BlockersError error
+}
+
+// LockHolder is a transaction that might have been blocking ours.
+type LockHolder struct {
+	PID            uint32
+	State          string
+	Query          string
+	TransactionAge time.Duration
+}
+
+func (d *LockDiagnostics) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "pid %d", d.PID)
+	if d.Detail != "" {
+		fmt.Fprintf(&sb, ": %s", d.Detail)
+	}
+	for _, b := range d.Blockers {
+		fmt.Fprintf(&sb, "; possibly blocked by pid %d (%s for %s): %s", b.PID, b.State, b.TransactionAge.Round(time.Millisecond), b.Query)
+	}
+	if d.BlockersError != nil {
+		fmt.Fprintf(&sb, "; failed to find blockers: %v", d.BlockersError)
+	}
+	return sb.String()
+}
+
+// lockDiagnosticsSlot is shared between all copies of an Error, so diagnostics can be attached after the runner returned it.
+type lockDiagnosticsSlot struct {
+	mu sync.Mutex
+	d  *LockDiagnostics
+}
+
+// LockDiagnostics returns the diagnostics gathered for a deadlock or lock timeout, or nil if there are none.
+func (e Error) LockDiagnostics() *LockDiagnostics {
+	if e.lock == nil {
+		return nil
+	}
+	e.lock.mu.Lock()
+	defer e.lock.mu.Unlock()
+	return e.lock.d
+}
+
+// collectLockDiagnostics attaches LockDiagnostics to err if it is a deadlock or lock timeout.
+// It must be called before the transaction is rolled back.
+func (t TrxWrap[Q]) collectLockDiagnostics(wtx *wrappedTransaction, err error) {
+	var e Error
+	var pge *pgconn.PgError
+	if !errors.As(err, &e) || e.lock == nil || !errors.As(e.parent, &pge) {
+		return
+	}
+	d := &LockDiagnostics{
+		PID:    wtx.tx.Conn().PgConn().PID(),
+		Detail: pge.Detail,
+	}
+	if pge.Code == "55P03" {
+		d.Blockers, d.BlockersError = t.findLockHolders(d.PID)
+	}
+	e.lock.mu.Lock()
+	e.lock.d = d
+	e.lock.mu.Unlock()
+}
+
+// findLockHolders returns the other transactions holding locks on relations that pid has locked.
+func (t TrxWrap[Q]) findLockHolders(pid uint32) ([]LockHolder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockDiagnosticsTimeout)
+	defer cancel()
+	tx, err := t.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	rows, err := tx.Query(ctx, `
+		SELECT a.pid, COALESCE(a.state, ''), COALESCE(a.query, ''), EXTRACT(EPOCH FROM now() - a.xact_start)::float8
+		FROM pg_stat_activity a
+		WHERE a.pid <> $1 AND a.pid <> pg_backend_pid() AND a.xact_start IS NOT NULL AND a.pid IN (
+			SELECT l.pid FROM pg_locks l WHERE l.granted AND l.relation IN (
+				SELECT relation FROM pg_locks WHERE pid = $1 AND relation IS NOT NULL
+			) AND l.mode IN ('ShareLock', 'ShareRowExclusiveLock', 'ExclusiveLock', 'AccessExclusiveLock')
+		)
+		ORDER BY a.xact_start
+		LIMIT 10`, int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []LockHolder
+	for rows.Next() {
+		var h LockHolder
+		var holderPID int32
+		var age float64
+		if err := rows.Scan(&holderPID, &h.State, &h.Query, &age); err != nil {
+			return nil, err
+		}
+		h.PID = uint32(holderPID)
+		h.TransactionAge = time.Duration(age * float64(time.Second))
+		ret = append(ret, h)
+	}
+	return ret, rows.Err()
+}
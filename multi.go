@@ -0,0 +1,35 @@
+package trxwrap
+
+import (
+	"fmt"
+)
+
+// Raw is the query type of a TrxWrap created with NewRaw. It gives access to the transaction itself, from which queries of any sqlc package can be created with Queries.
+type Raw struct {
+	DB PGDBTX
+}
+
+// NewRaw returns a TrxWrap whose runners get a *Raw, for transactions that span multiple sqlc packages:
+//
+//	db := trxwrap.NewRaw(pool)
+//	err := db.RunRWTransaction(ctx, pgx.Serializable, func(r *trxwrap.Raw) error {
+//		billing := trxwrap.Queries(r, billingdb.New)
+//		users := trxwrap.Queries(r, usersdb.New)
+//		// ...
+//	})
+func NewRaw(db PgxHandle, opts ...Option) TrxWrap[Raw] {
+	return New(db, func(tx PGDBTX) *Raw {
+		return &Raw{DB: tx}
+	}, opts...)
+}
+
+// Queries binds sqlc generated queries to the transaction of r. newQueries is typically the New function of a sqlc package.
+// It panics if the transaction doesn't implement the DBTX interface of that package.
+func Queries[D, Q any](r *Raw, newQueries func(D) *Q) *Q {
+	db, ok := r.DB.(D)
+	if !ok {
+		var d *D
+		panic(fmt.Errorf("trxwrap: transaction of type %T doesn't implement %T", r.DB, d))
+	}
+	return newQueries(db)
+}
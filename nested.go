@@ -0,0 +1,77 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrNestedTransaction is returned when a transaction is started while another one is active in the same call chain and NestedFail is configured.
+// A nested transaction needs a second connection while holding on to the first, which can deadlock when the pool is exhausted.
+var ErrNestedTransaction = errors.New("trxwrap: transaction started while another transaction is active")
+
+// NestedPolicy determines what happens when a transaction is started from within a runner.
+// Nesting is detected through the context passed to a ContextRunner, so runners that use their own context can't be detected.
+type NestedPolicy int
+
+const (
+	// NestedWarn calls Hooks.OnNestedTransaction and starts a separate transaction anyway. This is the default.
+	NestedWarn NestedPolicy = iota
+	// NestedFail calls Hooks.OnNestedTransaction and returns ErrNestedTransaction.
+	NestedFail
+	// NestedJoin calls Hooks.OnNestedTransaction and runs the inner runner as part of the outer transaction.
+	// The inner runner is not retried by itself and its error is returned to the outer runner.
+	// Joining a read-only transaction from a read-write transaction fails with ErrNestedTransaction, and so does joining from RunDryRun.
+	NestedJoin
+)
+
+// WithNestedPolicy configures what happens when a transaction is started from within a runner.
+func WithNestedPolicy(p NestedPolicy) Option {
+	return func(o *options) {
+		o.nested = p
+	}
+}
+
+type activeTransactionKey struct{}
+
+func withActiveTransaction(ctx context.Context, wtx *wrappedTransaction) context.Context {
+	return context.WithValue(ctx, activeTransactionKey{}, wtx)
+}
+
+// activeTransaction returns the transaction that is running in this call chain, or nil.
+func activeTransaction(ctx context.Context) *wrappedTransaction {
+	wtx, _ := ctx.Value(activeTransactionKey{}).(*wrappedTransaction)
+	if wtx == nil || wtx.isFinished() {
+		return nil
+	}
+	return wtx
+}
+
+// handleNested applies the NestedPolicy and returns whether the runner should join the outer transaction.
+func (t TrxWrap[Q]) handleNested(ctx context.Context, outer *wrappedTransaction, txo pgx.TxOptions, dryRun bool, pcs []uintptr) (bool, error) {
+	outerAt := describeCaller(outer.pcs)
+	innerAt := describeCaller(pcs)
+	if h := t.opts.hooks.OnNestedTransaction; h != nil {
+		h(ctx, outerAt, innerAt)
+	}
+	switch t.opts.nested {
+	case NestedFail:
+		err := fmt.Errorf("%w: outer transaction started at %s, inner at %s", ErrNestedTransaction, outerAt, innerAt)
+		if t.opts.strict {
+			panic(err)
+		}
+		return false, err
+	case NestedJoin:
+		if outer.txo.AccessMode == pgx.ReadOnly && txo.AccessMode != pgx.ReadOnly {
+			return false, fmt.Errorf("%w: can't join read-only transaction started at %s for a read-write transaction at %s", ErrNestedTransaction, outerAt, innerAt)
+		}
+		if dryRun {
+			// The outer transaction might commit, so joining it would make the dry run's writes stick.
+			return false, fmt.Errorf("%w: can't join transaction started at %s for a dry run at %s", ErrNestedTransaction, outerAt, innerAt)
+		}
+		return true, nil
+	}
+	return false, nil
+}
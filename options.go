@@ -0,0 +1,75 @@
+package trxwrap
+
+import (
+	"log"
+	"time"
+)
+
+// Option configures a TrxWrap. Options are passed to New.
+type Option func(*options)
+
+type options struct {
+	hooks           Hooks
+	logf            func(format string, args ...interface{})
+	panicsAsErrors  bool
+	strict          bool
+	concurrentUse   ConcurrentUsePolicy
+	nested          NestedPolicy
+	maxDuration     time.Duration
+	cancelLong      bool
+	lockDiagnostics bool
+	queryHooks      []QueryHook
+	flights         *flightGroup
+	groupCommit     *groupCommitConfig
+	groupCommitter  *groupCommitter
+	hedging         *Hedging
+	hedger          *hedger
+}
+
+// WithLogf sets the function used for logging. Defaults to log.Printf. Pass a no-op function to disable logging.
+// It isn't used by query hooks like SlowQueryLog, which have their own Logf.
+func WithLogf(logf func(format string, args ...interface{})) Option {
+	return func(o *options) {
+		o.logf = logf
+	}
+}
+
+func (o *options) log(format string, args ...interface{}) {
+	if o.logf == nil {
+		log.Printf(format, args...)
+		return
+	}
+	o.logf(format, args...)
+}
+
+// WithHooks installs callbacks that are invoked on notable events, see Hooks.
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.hooks = h
+	}
+}
+
+// WithPanicsAsErrors makes a panicking runner return a *PanicError instead of re-panicking after the transaction was rolled back.
+func WithPanicsAsErrors() Option {
+	return func(o *options) {
+		o.panicsAsErrors = true
+	}
+}
+
+// ConcurrentUsePolicy determines what happens when a transaction is used by multiple goroutines at the same time.
+type ConcurrentUsePolicy int
+
+const (
+	// ConcurrentUseFail makes overlapping calls fail with ErrConcurrentUse. This is the default.
+	ConcurrentUseFail ConcurrentUsePolicy = iota
+	// ConcurrentUseSerialize makes overlapping calls wait for each other.
+	// Note that this deadlocks if a goroutine issues a query while it still has Rows open.
+	ConcurrentUseSerialize
+)
+
+// WithConcurrentUse configures what happens when a transaction is used by multiple goroutines at the same time.
+func WithConcurrentUse(p ConcurrentUsePolicy) Option {
+	return func(o *options) {
+		o.concurrentUse = p
+	}
+}
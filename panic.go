@@ -0,0 +1,71 @@
+package trxwrap
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rollbackTimeout is how long we'll wait for a rollback after the runner panicked.
+const rollbackTimeout = 5 * time.Second
+
+// PanicError is returned when a runner panicked and WithPanicsAsErrors is set.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("trxwrap: runner panicked: %v\n%s", e.Value, e.Stack)
+}
+
+func (e *PanicError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, "internal error")
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// callRunner calls the runner and rolls back tx if the runner doesn't return normally.
+func (t TrxWrap[Q]) callRunner(ctx context.Context, wtx *wrappedTransaction, runner ContextRunner[Q], q *Q) (err error) {
+	tx := wtx.tx
+	returned := false
+	defer func() {
+		if returned {
+			if err != nil {
+				wtx.finish("returned an error")
+			} else {
+				wtx.finish("returned")
+			}
+			return
+		}
+		wtx.finish("panicked")
+		r := recover()
+		// The ctx of the caller might be the reason we're panicking, so use a fresh one to make sure we give the connection back.
+		rctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		tx.Rollback(rctx)
+		cancel()
+		if r == nil {
+			// Either runtime.Goexit() (e.g. t.FailNow()) or panic(nil). In the former case our return value is ignored.
+			err = &PanicError{Stack: debug.Stack()}
+			return
+		}
+		stack := debug.Stack()
+		if h := t.opts.hooks.OnPanic; h != nil {
+			h(ctx, r, stack)
+		}
+		if !t.opts.panicsAsErrors {
+			panic(r)
+		}
+		err = &PanicError{Value: r, Stack: stack}
+	}()
+	err = runner(ctx, q)
+	returned = true
+	return err
+}
@@ -0,0 +1,91 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestPanicRollsBack(t *testing.T) {
+	h := &fakeHandle{}
+	var hooked interface{}
+	db := NewRaw(h, WithHooks(Hooks{
+		OnPanic: func(ctx context.Context, v interface{}, stack []byte) {
+			hooked = v
+		},
+	}))
+	var recovered interface{}
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+			panic("boom")
+		})
+	}()
+	if recovered != "boom" {
+		t.Errorf("recovered %v, want boom", recovered)
+	}
+	if hooked != "boom" {
+		t.Errorf("OnPanic got %v, want boom", hooked)
+	}
+	txs := h.transactions()
+	if len(txs) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(txs))
+	}
+	if committed, rolledBack := txs[0].outcome(); committed || !rolledBack {
+		t.Errorf("committed=%v, rolledBack=%v; want a rollback", committed, rolledBack)
+	}
+}
+
+func TestPanicsAsErrors(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h, WithPanicsAsErrors())
+	err := db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+		panic("boom")
+	})
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v, want a *PanicError", err)
+	}
+	if pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Errorf("got PanicError{Value: %v, Stack: %d bytes}, want boom with a stack", pe.Value, len(pe.Stack))
+	}
+	if committed, rolledBack := h.transactions()[0].outcome(); committed || !rolledBack {
+		t.Errorf("committed=%v, rolledBack=%v; want a rollback", committed, rolledBack)
+	}
+}
+
+func TestGoexitRollsBack(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+			runtime.Goexit()
+			return nil
+		})
+	}()
+	<-done
+	if committed, rolledBack := h.transactions()[0].outcome(); committed || !rolledBack {
+		t.Errorf("committed=%v, rolledBack=%v; want a rollback", committed, rolledBack)
+	}
+}
+
+func TestReturnedErrorRollsBack(t *testing.T) {
+	h := &fakeHandle{}
+	db := NewRaw(h)
+	wantErr := errors.New("runner failed")
+	if err := db.RunRWTransaction(context.Background(), pgx.ReadCommitted, func(r *Raw) error {
+		return wantErr
+	}); err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+	if committed, rolledBack := h.transactions()[0].outcome(); committed || !rolledBack {
+		t.Errorf("committed=%v, rolledBack=%v; want a rollback", committed, rolledBack)
+	}
+}
@@ -0,0 +1,203 @@
+package trxwrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// QueryOp is the kind of call that executed a statement.
+type QueryOp string
+
+const (
+	QueryOpExec     QueryOp = "exec"
+	QueryOpQuery    QueryOp = "query"
+	QueryOpQueryRow QueryOp = "queryrow"
+	QueryOpCopyFrom QueryOp = "copyfrom"
+	QueryOpBatch    QueryOp = "batch"
+)
+
+// QueryEvent describes a statement executed through a transaction.
+type QueryEvent struct {
+	Op QueryOp
+	// SQL is the statement. For CopyFrom this is a description of the COPY. For batches these are the queued statements, separated by ";\n".
+	SQL string
+	// Name is the sqlc name of the query, if any. For batches it is only set if all queued statements have the same name.
+	Name string
+	Args []interface{}
+	// Start is when the call was made.
+	Start time.Time
+
+	// The fields below are only set for AfterQuery.
+
+	// RowsAffected is taken from the CommandTag, or the number of rows copied for CopyFrom.
+	RowsAffected int64
+	// Duration is the time until the call finished. For Query, QueryRow and batches this includes the time until the results were closed.
+	Duration time.Duration
+	// Err is the error returned to the caller, if any.
+	Err error
+}
+
+// QueryHook is invoked around every statement executed through a transaction.
+type QueryHook interface {
+	// BeforeQuery is called before the statement is executed. The returned context is used for the statement and passed to AfterQuery.
+	BeforeQuery(ctx context.Context, e *QueryEvent) context.Context
+	// AfterQuery is called once the statement is done.
+	AfterQuery(ctx context.Context, e *QueryEvent)
+}
+
+// WithQueryHook adds a QueryHook. It can be passed multiple times to install multiple hooks.
+func WithQueryHook(h QueryHook) Option {
+	return func(o *options) {
+		o.queryHooks = append(o.queryHooks, h)
+	}
+}
+
+// startQuery calls the BeforeQuery hooks. The returned event is nil if there are no hooks.
+func (t *wrappedTransaction) startQuery(ctx context.Context, op QueryOp, sql, name string, args []interface{}) (context.Context, *QueryEvent) {
+	return startQuery(ctx, t.opts.queryHooks, t.run.dryRun != nil, op, sql, name, args)
+}
+
+// endQuery calls the AfterQuery hooks.
+func (t *wrappedTransaction) endQuery(ctx context.Context, e *QueryEvent, rowsAffected int64, err error) {
+	endQuery(ctx, t.opts.queryHooks, e, rowsAffected, err)
+	if e != nil && t.run.dryRun != nil {
+		t.recordStatement(e)
+	}
+}
+
+// startQuery calls the BeforeQuery hooks. The returned event is nil if there are no hooks, unless always is set.
+func startQuery(ctx context.Context, hooks []QueryHook, always bool, op QueryOp, sql, name string, args []interface{}) (context.Context, *QueryEvent) {
+	if len(hooks) == 0 && !always {
+		return ctx, nil
+	}
+	e := &QueryEvent{
+		Op:    op,
+		SQL:   sql,
+		Name:  name,
+		Args:  args,
+		Start: time.Now(),
+	}
+	for _, h := range hooks {
+		ctx = h.BeforeQuery(ctx, e)
+	}
+	return ctx, e
+}
+
+// endQuery fills in the result of the query and calls the AfterQuery hooks.
+func endQuery(ctx context.Context, hooks []QueryHook, e *QueryEvent, rowsAffected int64, err error) {
+	if e == nil {
+		return
+	}
+	e.RowsAffected = rowsAffected
+	e.Duration = time.Since(e.Start)
+	e.Err = err
+	for _, h := range hooks {
+		h.AfterQuery(ctx, e)
+	}
+}
+
+// describeBatch returns the SQL and name for the QueryEvent of a batch.
+func describeBatch(queries []string) (sql, name string) {
+	for i, q := range queries {
+		n := QueryName(q)
+		if i == 0 {
+			name = n
+		} else if n != name {
+			name = ""
+		}
+	}
+	return strings.Join(queries, ";\n"), name
+}
+
+func describeCopy(tableName pgx.Identifier, columnNames []string) string {
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", tableName.Sanitize(), quoteColumns(columnNames))
+}
+
+// wrappedBatchResults wraps errors and releases the transaction once the results are closed.
+type wrappedBatchResults struct {
+	br           pgx.BatchResults
+	finish       func(rowsAffected int64, err error)
+	rowsAffected int64
+	err          error
+	closed       bool
+}
+
+func (b *wrappedBatchResults) record(rowsAffected int64, err error) error {
+	b.rowsAffected += rowsAffected
+	err = wrapError(err)
+	if b.err == nil && err != nil {
+		b.err = err
+	}
+	return err
+}
+
+func (b *wrappedBatchResults) Exec() (pgconn.CommandTag, error) {
+	ct, err := b.br.Exec()
+	return ct, b.record(ct.RowsAffected(), err)
+}
+
+func (b *wrappedBatchResults) Query() (pgx.Rows, error) {
+	rows, err := b.br.Query()
+	return rows, b.record(0, err)
+}
+
+func (b *wrappedBatchResults) QueryRow() pgx.Row {
+	return batchRow{b, b.br.QueryRow()}
+}
+
+func (b *wrappedBatchResults) QueryFunc(scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	ct, err := b.br.QueryFunc(scans, f)
+	return ct, b.record(ct.RowsAffected(), err)
+}
+
+func (b *wrappedBatchResults) Close() error {
+	err := b.record(0, b.br.Close())
+	if !b.closed {
+		b.closed = true
+		b.finish(b.rowsAffected, b.err)
+	}
+	return err
+}
+
+type batchRow struct {
+	b   *wrappedBatchResults
+	row pgx.Row
+}
+
+func (r batchRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err == pgx.ErrNoRows {
+		return err
+	}
+	return r.b.record(0, err)
+}
+
+// wrappedBatchResultsError is returned by SendBatch if the batch couldn't be sent.
+type wrappedBatchResultsError struct {
+	err error
+}
+
+func (b wrappedBatchResultsError) Exec() (pgconn.CommandTag, error) {
+	return nil, b.err
+}
+
+func (b wrappedBatchResultsError) Query() (pgx.Rows, error) {
+	return nil, b.err
+}
+
+func (b wrappedBatchResultsError) QueryRow() pgx.Row {
+	return wrappedRowError{b.err}
+}
+
+func (b wrappedBatchResultsError) QueryFunc(scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, b.err
+}
+
+func (b wrappedBatchResultsError) Close() error {
+	return b.err
+}
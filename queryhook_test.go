@@ -0,0 +1,22 @@
+package trxwrap
+
+import "testing"
+
+func TestDescribeBatch(t *testing.T) {
+	tests := []struct {
+		queries  []string
+		wantSQL  string
+		wantName string
+	}{
+		{nil, "", ""},
+		{[]string{"-- name: AddUser :batchexec\nINSERT INTO users VALUES ($1)", "-- name: AddUser :batchexec\nINSERT INTO users VALUES ($1)"}, "-- name: AddUser :batchexec\nINSERT INTO users VALUES ($1);\n-- name: AddUser :batchexec\nINSERT INTO users VALUES ($1)", "AddUser"},
+		{[]string{"-- name: A :exec\nSELECT 1", "-- name: B :exec\nSELECT 2"}, "-- name: A :exec\nSELECT 1;\n-- name: B :exec\nSELECT 2", ""},
+		{[]string{"-- name: A :exec\nSELECT 1", "SELECT 2", "-- name: A :exec\nSELECT 1"}, "-- name: A :exec\nSELECT 1;\nSELECT 2;\n-- name: A :exec\nSELECT 1", ""},
+	}
+	for _, tt := range tests {
+		sql, name := describeBatch(tt.queries)
+		if sql != tt.wantSQL || name != tt.wantName {
+			t.Errorf("describeBatch(%q) = %q, %q; want %q, %q", tt.queries, sql, name, tt.wantSQL, tt.wantName)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package trxwrap
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrTooManyRows is matched by TooManyRowsError.
+var ErrTooManyRows = errors.New("trxwrap: query returned too many rows")
+
+// TooManyRowsError is returned by Rows.Err() when a query returned more rows than allowed by WithMaxRows.
+type TooManyRowsError struct {
+	// Query is the sqlc name of the query.
+	Query string
+	Limit int
+}
+
+func (e *TooManyRowsError) Error() string {
+	return fmt.Sprintf("%v: %s returned more than %d rows", ErrTooManyRows, e.Query, e.Limit)
+}
+
+func (e *TooManyRowsError) Is(target error) bool {
+	return target == ErrTooManyRows
+}
+
+func (e *TooManyRowsError) GRPCStatus() *status.Status {
+	return status.New(codes.ResourceExhausted, "too many results")
+}
+
+// WithMaxRows limits the number of rows a single query can return.
+// Once a query returns more rows, the rows are closed and Rows.Err() returns a *TooManyRowsError.
+func WithMaxRows(n int) RunOption {
+	return func(o *runOptions) {
+		o.maxRows = n
+	}
+}
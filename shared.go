@@ -0,0 +1,148 @@
+package trxwrap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// flightGroup deduplicates concurrent calls with the same key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	val     interface{}
+	err     error
+	panic   interface{}
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// RunSharedROTransaction runs a read-only transaction like RunROTransactionContext, but concurrent calls with the same key share a single transaction and its result.
+// The key must identify the runner and everything it depends on, and the returned value must not be modified as it's shared with other callers.
+// Each caller returns as soon as its ctx is canceled. The shared transaction is only canceled once all callers are gone.
+// The shared transaction can't be part of the caller's transaction, so calling this from within a runner fails with ErrNestedTransaction.
+func RunSharedROTransaction[Q, T any](ctx context.Context, t TrxWrap[Q], key string, isolationLevel pgx.TxIsoLevel, runner func(context.Context, *Q) (T, error)) (T, error) {
+	if outer := activeTransaction(ctx); outer != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: can't share a transaction from within the transaction started at %s", ErrNestedTransaction, describeCaller(outer.pcs))
+	}
+	run := func(ctx context.Context) (T, error) {
+		var ret T
+		err := t.RunROTransactionContext(ctx, isolationLevel, func(ctx context.Context, q *Q) error {
+			var err error
+			ret, err = runner(ctx, q)
+			return err
+		})
+		return ret, err
+	}
+	if t.opts.flights == nil {
+		return run(ctx)
+	}
+	f := t.opts.flights.join(ctx, key, func(ctx context.Context) (interface{}, error) {
+		ret, err := run(ctx)
+		return sharedResult[T]{ret}, err
+	})
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		t.opts.flights.leave(key, f)
+		var zero T
+		return zero, ctx.Err()
+	}
+	if f.panic != nil {
+		panic(f.panic)
+	}
+	ret, ok := f.val.(sharedResult[T])
+	if !ok {
+		// Another caller used the same key for a different type.
+		return run(ctx)
+	}
+	return ret.val, f.err
+}
+
+// sharedResult wraps the result of a flight, so a nil interface can be told apart from a result of another type.
+type sharedResult[T any] struct {
+	val T
+}
+
+// join returns the flight for key, starting f if there is none.
+func (g *flightGroup) join(ctx context.Context, key string, f func(context.Context) (interface{}, error)) *flight {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if fl, ok := g.flights[key]; ok {
+		fl.waiters++
+		return fl
+	}
+	if g.flights == nil {
+		g.flights = map[string]*flight{}
+	}
+	fctx, cancel := context.WithCancel(detachedContext{ctx})
+	fl := &flight{
+		done:    make(chan struct{}),
+		waiters: 1,
+		cancel:  cancel,
+	}
+	g.flights[key] = fl
+	go func() {
+		defer close(fl.done)
+		defer cancel()
+		defer func() {
+			fl.panic = recover()
+		}()
+		defer g.forget(key, fl)
+		fl.val, fl.err = f(fctx)
+	}()
+	return fl
+}
+
+// leave is called when a waiter gives up. The flight is canceled when nobody is waiting for it anymore.
+func (g *flightGroup) leave(key string, fl *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fl.waiters--
+	if fl.waiters == 0 {
+		fl.cancel()
+		if g.flights[key] == fl {
+			delete(g.flights, key)
+		}
+	}
+}
+
+func (g *flightGroup) forget(key string, fl *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.flights[key] == fl {
+		delete(g.flights, key)
+	}
+}
+
+// detachedContext has the values of its parent, but not its deadline, cancellation or active transaction.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	if key == (activeTransactionKey{}) {
+		return nil
+	}
+	return c.parent.Value(key)
+}
@@ -0,0 +1,38 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestSharedNilInterfaceResult(t *testing.T) {
+	db := NewRaw(&fakeHandle{})
+	runs := 0
+	ret, err := RunSharedROTransaction(context.Background(), db, "key", pgx.ReadCommitted, func(ctx context.Context, r *Raw) (fmt.Stringer, error) {
+		runs++
+		return nil, nil
+	})
+	if ret != nil || err != nil {
+		t.Errorf("got %v, %v; want nil, nil", ret, err)
+	}
+	if runs != 1 {
+		t.Errorf("runner ran %d times, want 1", runs)
+	}
+}
+
+func TestSharedRejectsNesting(t *testing.T) {
+	db := NewRaw(&fakeHandle{})
+	err := db.RunROTransactionContext(context.Background(), pgx.ReadCommitted, func(ctx context.Context, r *Raw) error {
+		_, err := RunSharedROTransaction(ctx, db, "key", pgx.ReadCommitted, func(ctx context.Context, r *Raw) (int, error) {
+			return 1, nil
+		})
+		return err
+	})
+	if !errors.Is(err, ErrNestedTransaction) {
+		t.Errorf("got %v, want ErrNestedTransaction", err)
+	}
+}
@@ -0,0 +1,83 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// AllArgs can be used in SlowQueryLog.AllowedArgs to allow logging all arguments of a query.
+const AllArgs = -1
+
+// SlowQueryLog is a QueryHook that logs statements that take longer than a threshold.
+// Arguments are redacted unless they are explicitly allowed, and errors from the server are logged by their SQLSTATE only, as their messages can contain values.
+type SlowQueryLog struct {
+	// Threshold is the default threshold. If zero, only queries in Thresholds are logged.
+	Threshold time.Duration
+	// Thresholds overrides Threshold per sqlc query name.
+	Thresholds map[string]time.Duration
+	// AllowedArgs lists per sqlc query name which argument positions (starting at 0) can be logged.
+	AllowedArgs map[string][]int
+	// Logf is used for logging. Defaults to log.Printf, not to the function given to WithLogf, as a hook doesn't know which TrxWrap it's installed in.
+	Logf func(format string, args ...interface{})
+}
+
+var _ QueryHook = (*SlowQueryLog)(nil)
+
+func (l *SlowQueryLog) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (l *SlowQueryLog) AfterQuery(ctx context.Context, e *QueryEvent) {
+	threshold, ok := l.Thresholds[e.Name]
+	if !ok {
+		threshold = l.Threshold
+	}
+	if threshold <= 0 || e.Duration < threshold {
+		return
+	}
+	logf := l.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	name := e.Name
+	if name == "" {
+		name = string(e.Op)
+	}
+	msg := fmt.Sprintf("trxwrap: slow query %s took %s: %s; args: %s", name, e.Duration, e.SQL, l.redactArgs(e.Name, e.Args))
+	if e.Err != nil {
+		msg += "; error: " + redactError(e.Err)
+	}
+	logf("%s", msg)
+}
+
+// redactArgs formats the arguments of a query, redacting those not allowed by AllowedArgs.
+func (l *SlowQueryLog) redactArgs(name string, args []interface{}) string {
+	allowed := map[int]bool{}
+	for _, i := range l.AllowedArgs[name] {
+		allowed[i] = true
+	}
+	ret := make([]string, len(args))
+	for i, a := range args {
+		if allowed[AllArgs] || allowed[i] {
+			ret[i] = fmt.Sprintf("%v", a)
+		} else {
+			ret[i] = "<redacted>"
+		}
+	}
+	return "[" + strings.Join(ret, ", ") + "]"
+}
+
+// redactError describes an error without including messages from the server.
+func redactError(err error) string {
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) {
+		return "SQLSTATE " + pge.Code
+	}
+	return err.Error()
+}
@@ -0,0 +1,19 @@
+package trxwrap
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Transactor runs transactions. It is implemented by TrxWrap, and by trxwraptest.MockTransactor for unit tests that shouldn't need a database.
+type Transactor[Q any] interface {
+	RunRWTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q], opts ...RunOption) error
+	RunROTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner TransactionRunner[Q], opts ...RunOption) error
+	RunTransaction(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner TransactionRunner[Q], opts ...RunOption) error
+	RunRWTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner ContextRunner[Q], opts ...RunOption) error
+	RunROTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner ContextRunner[Q], opts ...RunOption) error
+	RunTransactionContext(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner ContextRunner[Q], opts ...RunOption) error
+}
+
+var _ Transactor[struct{}] = TrxWrap[struct{}]{}
@@ -0,0 +1,103 @@
+package trxwraptest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Jille/trxwrap"
+	"github.com/jackc/pgx/v4"
+)
+
+// MockTransactor is a trxwrap.Transactor that runs runners against a fixed *Q, without a database.
+// Q is typically a fake implementation of your queries.
+type MockTransactor[Q any] struct {
+	// Q is passed to every runner.
+	Q *Q
+	// Attempts is how often each runner is run, to simulate retries. Errors of all but the last attempt are ignored.
+	// Zero means once.
+	Attempts int
+	// Err, if set, is called after each successful runner to simulate a failing commit.
+	// attempt starts at 0. A non-nil error makes the transaction fail with that error.
+	Err func(attempt int) error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Call is a transaction that was run by MockTransactor.
+type Call struct {
+	TxOptions  pgx.TxOptions
+	Idempotent bool
+	// Attempts is the number of times the runner was called.
+	Attempts int
+	Err      error
+}
+
+var _ trxwrap.Transactor[struct{}] = &MockTransactor[struct{}]{}
+
+func (m *MockTransactor[Q]) RunRWTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.TransactionRunner[Q], opts ...trxwrap.RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadWrite,
+	}
+	return m.RunTransaction(ctx, txo, false, runner, opts...)
+}
+
+func (m *MockTransactor[Q]) RunROTransaction(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.TransactionRunner[Q], opts ...trxwrap.RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadOnly,
+	}
+	return m.RunTransaction(ctx, txo, true, runner, opts...)
+}
+
+func (m *MockTransactor[Q]) RunTransaction(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner trxwrap.TransactionRunner[Q], opts ...trxwrap.RunOption) error {
+	return m.RunTransactionContext(ctx, txo, idempotent, func(ctx context.Context, q *Q) error {
+		return runner(q)
+	}, opts...)
+}
+
+func (m *MockTransactor[Q]) RunRWTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.ContextRunner[Q], opts ...trxwrap.RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadWrite,
+	}
+	return m.RunTransactionContext(ctx, txo, false, runner, opts...)
+}
+
+func (m *MockTransactor[Q]) RunROTransactionContext(ctx context.Context, isolationLevel pgx.TxIsoLevel, runner trxwrap.ContextRunner[Q], opts ...trxwrap.RunOption) error {
+	txo := pgx.TxOptions{
+		IsoLevel:   isolationLevel,
+		AccessMode: pgx.ReadOnly,
+	}
+	return m.RunTransactionContext(ctx, txo, true, runner, opts...)
+}
+
+func (m *MockTransactor[Q]) RunTransactionContext(ctx context.Context, txo pgx.TxOptions, idempotent bool, runner trxwrap.ContextRunner[Q], opts ...trxwrap.RunOption) error {
+	attempts := m.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	c := Call{
+		TxOptions:  txo,
+		Idempotent: idempotent,
+	}
+	for c.Attempts < attempts {
+		c.Err = runner(ctx, m.Q)
+		if c.Err == nil && m.Err != nil {
+			c.Err = m.Err(c.Attempts)
+		}
+		c.Attempts++
+	}
+	m.mu.Lock()
+	m.calls = append(m.calls, c)
+	m.mu.Unlock()
+	return c.Err
+}
+
+// Calls returns the transactions that were run so far.
+func (m *MockTransactor[Q]) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
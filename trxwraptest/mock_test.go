@@ -0,0 +1,96 @@
+package trxwraptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type fakeQueries struct{}
+
+func TestMockTransactorAttempts(t *testing.T) {
+	commitErr := errors.New("commit failed")
+	m := &MockTransactor[fakeQueries]{
+		Q:        &fakeQueries{},
+		Attempts: 3,
+		Err: func(attempt int) error {
+			if attempt < 2 {
+				return commitErr
+			}
+			return nil
+		},
+	}
+	runs := 0
+	if err := m.RunRWTransaction(context.Background(), pgx.Serializable, func(q *fakeQueries) error {
+		if q != m.Q {
+			t.Errorf("runner got %p, want %p", q, m.Q)
+		}
+		runs++
+		return nil
+	}); err != nil {
+		t.Errorf("RunRWTransaction() = %v, want nil as only earlier attempts failed", err)
+	}
+	if runs != 3 {
+		t.Errorf("runner ran %d times, want 3", runs)
+	}
+	calls := m.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	want := Call{
+		TxOptions:  pgx.TxOptions{IsoLevel: pgx.Serializable, AccessMode: pgx.ReadWrite},
+		Idempotent: false,
+		Attempts:   3,
+	}
+	if calls[0] != want {
+		t.Errorf("got %+v, want %+v", calls[0], want)
+	}
+}
+
+func TestMockTransactorLastAttemptFails(t *testing.T) {
+	runnerErr := errors.New("runner failed")
+	m := &MockTransactor[fakeQueries]{Q: &fakeQueries{}}
+	if err := m.RunROTransaction(context.Background(), pgx.ReadCommitted, func(q *fakeQueries) error {
+		return runnerErr
+	}); err != runnerErr {
+		t.Errorf("RunROTransaction() = %v, want %v", err, runnerErr)
+	}
+	commitErr := errors.New("commit failed")
+	m.Err = func(attempt int) error {
+		return commitErr
+	}
+	if err := m.RunTransaction(context.Background(), pgx.TxOptions{}, true, func(q *fakeQueries) error {
+		return nil
+	}); err != commitErr {
+		t.Errorf("RunTransaction() = %v, want %v", err, commitErr)
+	}
+	calls := m.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if c := calls[0]; c.TxOptions.AccessMode != pgx.ReadOnly || !c.Idempotent || c.Attempts != 1 || c.Err != runnerErr {
+		t.Errorf("first call: got %+v, want a read-only idempotent call failing with %v", c, runnerErr)
+	}
+	if c := calls[1]; c.Err != commitErr {
+		t.Errorf("second call: got %+v, want it to fail with %v", c, commitErr)
+	}
+}
+
+func TestMockTransactorContext(t *testing.T) {
+	type key struct{}
+	m := &MockTransactor[fakeQueries]{Q: &fakeQueries{}}
+	ctx := context.WithValue(context.Background(), key{}, "v")
+	if err := m.RunROTransactionContext(ctx, pgx.ReadCommitted, func(ctx context.Context, q *fakeQueries) error {
+		if ctx.Value(key{}) != "v" {
+			t.Error("runner didn't get the caller's context")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("RunROTransactionContext() = %v", err)
+	}
+	if calls := m.Calls(); len(calls) != 1 || calls[0].TxOptions.AccessMode != pgx.ReadOnly || !calls[0].Idempotent {
+		t.Errorf("got calls %+v, want one read-only idempotent call", calls)
+	}
+}
@@ -0,0 +1,227 @@
+// Package trxwraptest provides helpers for testing code that uses trxwrap.
+package trxwraptest
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/Jille/trxwrap"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// NewTestWrap returns a TrxWrap that runs every transaction as a savepoint within a single transaction, which is rolled back when the test finishes.
+// This isolates tests from each other without having to truncate tables.
+// Transactions are run one at a time. Their isolation level and access mode are ignored, though trxwrap still rejects statements like CREATE in read-only transactions. Other writes in read-only transactions aren't caught.
+// Statements on Autocommit() run in a savepoint of their own.
+// A transaction (or Autocommit() statement) started by the goroutine running a runner is run in a savepoint within the runner's savepoint, so it is rolled back if the outer one is.
+// Strict mode is enabled, so misuse of transactions panics.
+func NewTestWrap[Q any](t testing.TB, handle trxwrap.PgxHandle, gendb func(trxwrap.PGDBTX) *Q, opts ...trxwrap.Option) trxwrap.TrxWrap[Q] {
+	t.Helper()
+	tx, err := handle.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		t.Fatalf("trxwraptest: failed to begin transaction: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Errorf("trxwraptest: failed to roll back transaction: %v", err)
+		}
+	})
+	opts = append([]trxwrap.Option{trxwrap.WithStrictMode()}, opts...)
+	return trxwrap.New(newSavepointHandle(tx), gendb, opts...)
+}
+
+// savepointHandle is a trxwrap.PgxHandle that begins savepoints within a transaction.
+type savepointHandle struct {
+	tx pgx.Tx
+	// sem is held while a savepoint is active, as the connection can only be used for one at a time.
+	sem chan struct{}
+
+	mu sync.Mutex
+	// owner is the goroutine that holds sem, and active its innermost savepoint.
+	owner  uint64
+	active *savepoint
+}
+
+func newSavepointHandle(tx pgx.Tx) *savepointHandle {
+	return &savepointHandle{
+		tx:  tx,
+		sem: make(chan struct{}, 1),
+	}
+}
+
+func (h *savepointHandle) BeginTx(ctx context.Context, txo pgx.TxOptions) (pgx.Tx, error) {
+	gid := currentGoroutineID()
+	h.mu.Lock()
+	parent, owner := h.active, h.owner
+	h.mu.Unlock()
+	if parent != nil && owner == gid {
+		// Waiting for sem would wait for ourselves, so nest the savepoint instead.
+		sp, err := parent.Tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s := &savepoint{Tx: sp, h: h, parent: parent}
+		h.setActive(gid, s)
+		return s, nil
+	}
+	select {
+	case h.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	sp, err := h.tx.Begin(ctx)
+	if err != nil {
+		<-h.sem
+		return nil, err
+	}
+	s := &savepoint{Tx: sp, h: h}
+	h.setActive(gid, s)
+	return s, nil
+}
+
+func (h *savepointHandle) setActive(owner uint64, s *savepoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.owner = owner
+	h.active = s
+}
+
+// savepoint releases the savepointHandle once it is committed or rolled back.
+type savepoint struct {
+	pgx.Tx
+	h *savepointHandle
+	// parent is the savepoint this one is nested in, or nil if it holds sem.
+	parent   *savepoint
+	released bool
+}
+
+func (s *savepoint) Commit(ctx context.Context) error {
+	defer s.release()
+	return s.Tx.Commit(ctx)
+}
+
+func (s *savepoint) Rollback(ctx context.Context) error {
+	defer s.release()
+	return s.Tx.Rollback(ctx)
+}
+
+func (s *savepoint) release() {
+	if s.released {
+		return
+	}
+	s.released = true
+	s.h.mu.Lock()
+	s.h.active = s.parent
+	s.h.mu.Unlock()
+	if s.parent == nil {
+		<-s.h.sem
+	}
+}
+
+// Exec runs a statement in its own savepoint, so it can be used by TrxWrap.Autocommit.
+func (h *savepointHandle) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	sp, err := h.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ct, err := sp.Exec(ctx, sql, args...)
+	if err != nil {
+		sp.Rollback(context.Background())
+		return nil, err
+	}
+	return ct, sp.Commit(ctx)
+}
+
+// Query runs a query in its own savepoint, which is released once the rows are closed.
+func (h *savepointHandle) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	sp, err := h.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sp.Query(ctx, sql, args...)
+	if err != nil {
+		sp.Rollback(context.Background())
+		return nil, err
+	}
+	return &savepointRows{Rows: rows, ctx: ctx, sp: sp}, nil
+}
+
+// QueryRow runs a query in its own savepoint once Scan is called.
+func (h *savepointHandle) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return savepointRow{h, ctx, sql, args}
+}
+
+// savepointRows ends its savepoint when the rows are closed, which pgx also does once Next returns false.
+type savepointRows struct {
+	pgx.Rows
+	ctx    context.Context
+	sp     pgx.Tx
+	closed bool
+	err    error
+}
+
+func (r *savepointRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *savepointRows) Close() {
+	r.Rows.Close()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.Rows.Err() != nil {
+		r.sp.Rollback(context.Background())
+	} else if err := r.sp.Commit(r.ctx); err != nil {
+		r.err = err
+		r.sp.Rollback(context.Background())
+	}
+}
+
+func (r *savepointRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
+
+type savepointRow struct {
+	h    *savepointHandle
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+func (r savepointRow) Scan(dest ...interface{}) error {
+	sp, err := r.h.BeginTx(r.ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	if err := sp.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...); err != nil {
+		sp.Rollback(context.Background())
+		return err
+	}
+	return sp.Commit(r.ctx)
+}
+
+func currentGoroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	var id uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
@@ -0,0 +1,96 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var upsertCounter uint64
+
+// ErrInvalidUpsert is returned by BulkUpsert if the Upsert can't be turned into valid SQL.
+var ErrInvalidUpsert = errors.New("trxwrap: invalid Upsert")
+
+// Upsert describes a bulk upsert done by BulkUpsert.
+type Upsert struct {
+	Table   pgx.Identifier
+	Columns []string
+	// ConflictColumns are the columns of the unique index to check for conflicts.
+	ConflictColumns []string
+	// UpdateColumns are set to the new values on conflict. If empty, conflicting rows are left alone.
+	// PostgreSQL needs ConflictColumns to know which conflicts to update.
+	UpdateColumns []string
+	// OnConflict overrides the clause generated from ConflictColumns and UpdateColumns, e.g. "ON CONFLICT ON CONSTRAINT pk DO UPDATE SET x = EXCLUDED.x WHERE ...".
+	OnConflict string
+}
+
+// BulkUpsert copies rows into a temporary table with CopyFrom and merges them into the target table with INSERT ... ON CONFLICT.
+// This is much faster than upserting rows one by one. It returns how many rows were inserted and updated.
+// ctx must be the context given to the runner by RunTransactionContext and friends.
+func BulkUpsert(ctx context.Context, u Upsert, rows pgx.CopyFromSource) (inserted, updated int64, err error) {
+	wtx := activeTransaction(ctx)
+	if wtx == nil {
+		return 0, 0, ErrNoTransaction
+	}
+	if err := u.validate(); err != nil {
+		return 0, 0, err
+	}
+	tmp := pgx.Identifier{fmt.Sprintf("trxwrap_upsert_%d", atomic.AddUint64(&upsertCounter, 1))}
+	cols := quoteColumns(u.Columns)
+	if _, err := wtx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", tmp.Sanitize(), cols, u.Table.Sanitize())); err != nil {
+		return 0, 0, err
+	}
+	if _, err := wtx.CopyFrom(ctx, tmp, u.Columns, rows); err != nil {
+		return 0, 0, err
+	}
+	// Counting in SQL avoids streaming a row back for every upserted row.
+	merge := fmt.Sprintf("WITH m AS (INSERT INTO %s (%s) SELECT %s FROM %s %s RETURNING (xmax = 0) AS ins) SELECT count(*) FILTER (WHERE ins), count(*) FILTER (WHERE NOT ins) FROM m", u.Table.Sanitize(), cols, cols, tmp.Sanitize(), u.conflictClause())
+	if err := wtx.QueryRow(ctx, merge).Scan(&inserted, &updated); err != nil {
+		return 0, 0, err
+	}
+	if _, err := wtx.Exec(ctx, "DROP TABLE "+tmp.Sanitize()); err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, nil
+}
+
+func (u Upsert) validate() error {
+	if len(u.Columns) == 0 {
+		return fmt.Errorf("%w: no Columns", ErrInvalidUpsert)
+	}
+	if u.OnConflict == "" && len(u.UpdateColumns) > 0 && len(u.ConflictColumns) == 0 {
+		return fmt.Errorf("%w: UpdateColumns requires ConflictColumns", ErrInvalidUpsert)
+	}
+	return nil
+}
+
+func (u Upsert) conflictClause() string {
+	if u.OnConflict != "" {
+		return u.OnConflict
+	}
+	target := ""
+	if len(u.ConflictColumns) > 0 {
+		target = "(" + quoteColumns(u.ConflictColumns) + ") "
+	}
+	if len(u.UpdateColumns) == 0 {
+		return "ON CONFLICT " + target + "DO NOTHING"
+	}
+	sets := make([]string, len(u.UpdateColumns))
+	for i, c := range u.UpdateColumns {
+		q := pgx.Identifier{c}.Sanitize()
+		sets[i] = q + " = EXCLUDED." + q
+	}
+	return "ON CONFLICT " + target + "DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+func quoteColumns(columns []string) string {
+	ret := make([]string, len(columns))
+	for i, c := range columns {
+		ret[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return strings.Join(ret, ", ")
+}
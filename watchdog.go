@@ -0,0 +1,131 @@
+package trxwrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ErrTransactionTooLong is returned by queries after a transaction was aborted for exceeding the duration configured with WithMaxTransactionDuration.
+var ErrTransactionTooLong = errors.New("trxwrap: transaction exceeded its maximum duration")
+
+// LongTransactionEvent describes a transaction that exceeded its maximum duration.
+type LongTransactionEvent struct {
+	// StartedAt is the location of the code that started the transaction.
+	StartedAt string
+	// Runner is the location of the runner.
+	Runner string
+	// Query is the sqlc name of the query in progress, if any.
+	Query string
+	// Duration is how long the transaction has been running.
+	Duration time.Duration
+	// Stack is the stack trace of the goroutine running the runner.
+	Stack []byte
+	// Canceled is whether the transaction was aborted.
+	Canceled bool
+}
+
+// WithMaxTransactionDuration configures how long a runner may hold on to a transaction.
+// When the limit is exceeded, Hooks.OnLongTransaction is called. If that hook isn't set, the stack of the runner is logged instead (see WithLogf).
+// If cancel is set, the context passed to a ContextRunner is canceled, the running query is canceled and any further queries fail with ErrTransactionTooLong.
+func WithMaxTransactionDuration(d time.Duration, cancel bool) Option {
+	return func(o *options) {
+		o.maxDuration = d
+		o.cancelLong = cancel
+	}
+}
+
+// startWatchdog starts a timer that fires when the transaction exceeds the maximum duration.
+// It returns the context the runner should use and a function to stop the watchdog.
+func (t TrxWrap[Q]) startWatchdog(ctx context.Context, wtx *wrappedTransaction) (context.Context, func()) {
+	if t.opts.maxDuration <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	start := time.Now()
+	gid := currentGoroutineID()
+	timer := time.AfterFunc(t.opts.maxDuration, func() {
+		if wtx.isFinished() {
+			return
+		}
+		e := LongTransactionEvent{
+			StartedAt: describeCaller(wtx.pcs),
+			Runner:    describeFunc(wtx.runner),
+			Query:     wtx.currentQuery(),
+			Duration:  time.Since(start),
+			Stack:     goroutineStack(gid),
+			Canceled:  t.opts.cancelLong,
+		}
+		if h := t.opts.hooks.OnLongTransaction; h != nil {
+			h(ctx, e)
+		} else {
+			t.opts.log("trxwrap: transaction started at %s has been running for %s:\n%s", e.StartedAt, e.Duration, e.Stack)
+		}
+		if t.opts.cancelLong {
+			wtx.abort(ErrTransactionTooLong)
+			cancel()
+		}
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// abort makes all further queries fail with err and cancels the query in progress, if any.
+func (t *wrappedTransaction) abort(err error) {
+	t.mu.Lock()
+	t.aborted = err
+	inQuery := t.inQuery
+	t.mu.Unlock()
+	if !inQuery {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	t.tx.Conn().PgConn().CancelRequest(ctx)
+}
+
+func (t *wrappedTransaction) abortError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.aborted
+}
+
+// currentGoroutineID returns the ID of the calling goroutine, or 0 if it can't be determined.
+func currentGoroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	var id uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
+
+// goroutineStack returns the stack trace of the given goroutine.
+func goroutineStack(id uint64) []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := []byte(fmt.Sprintf("goroutine %d ", id))
+	for _, s := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(s, prefix) {
+			return s
+		}
+	}
+	return nil
+}
@@ -2,6 +2,9 @@ package trxwrap
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
@@ -13,6 +16,7 @@ type wrappedRow struct {
 
 func (r wrappedRow) Scan(dest ...interface{}) error {
 	if r.rows.Err() != nil {
+		r.rows.Close()
 		return r.rows.Err()
 	}
 
@@ -36,29 +40,273 @@ func (r wrappedRowError) Scan(dest ...interface{}) error {
 	return r.err
 }
 
+// wrappedRows releases the transaction once the rows are closed or exhausted, and enforces the row limit.
+type wrappedRows struct {
+	pgx.Rows
+	release  func()
+	released bool
+	name     string
+	limit    int
+	seen     int
+	err      error
+}
+
+func (r *wrappedRows) Next() bool {
+	if r.err == nil && r.Rows.Next() {
+		r.seen++
+		if r.limit <= 0 || r.seen <= r.limit {
+			return true
+		}
+		r.err = &TooManyRowsError{Query: r.name, Limit: r.limit}
+		r.Rows.Close()
+	}
+	r.done()
+	return false
+}
+
+func (r *wrappedRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return wrapQueryError(r.Rows.Err(), r.name)
+}
+
+func (r *wrappedRows) Close() {
+	r.Rows.Close()
+	r.done()
+}
+
+func (r *wrappedRows) done() {
+	if !r.released {
+		r.released = true
+		r.release()
+	}
+}
+
+// ErrConcurrentUse is returned when a transaction is used by multiple goroutines at the same time.
+// pgx transactions are bound to a single connection, which can only run one query at a time.
+var ErrConcurrentUse = errors.New("trxwrap: transaction used concurrently by multiple goroutines (or a query was issued while Rows were still open)")
+
 type wrappedTransaction struct {
-	tx pgx.Tx
+	tx     pgx.Tx
+	txo    pgx.TxOptions
+	opts   *options
+	run    *runOptions
+	runner interface{}
+	pcs    []uintptr
+
+	// busy is held while a query is in progress or Rows are open.
+	busy sync.Mutex
+
+	mu       sync.Mutex
+	finished *TransactionFinishedError
+	aborted  error
+	// inQuery is true while busy is held by a query or open Rows. current is the name of that query.
+	inQuery bool
+	current string
+	// statements counts the statements executed, in total and per query.
+	statements int
+	perQuery   map[string]int
+	// log records the statements executed in a dry run.
+	log []DryRunStatement
+}
+
+func newWrappedTransaction(tx pgx.Tx, txo pgx.TxOptions, opts *options, run *runOptions, runner interface{}, pcs []uintptr) *wrappedTransaction {
+	return &wrappedTransaction{
+		tx:     tx,
+		txo:    txo,
+		opts:   opts,
+		run:    run,
+		runner: runner,
+		pcs:    pcs,
+	}
+}
+
+// finish marks the transaction as finished. Any later calls will fail.
+func (t *wrappedTransaction) finish(outcome string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished = &TransactionFinishedError{
+		EndedAt: time.Now(),
+		Outcome: outcome,
+	}
 }
 
-func (t wrappedTransaction) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+func (t *wrappedTransaction) isFinished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.finished != nil
+}
+
+// checkUsable returns an error if the transaction can no longer be used.
+func (t *wrappedTransaction) checkUsable() error {
+	t.mu.Lock()
+	fe := t.finished
+	aborted := t.aborted
+	t.mu.Unlock()
+	if fe == nil {
+		return aborted
+	}
+	err := *fe
+	err.StartedAt = describeCaller(t.pcs)
+	err.Runner = describeFunc(t.runner)
+	if t.opts.strict {
+		panic(&err)
+	}
+	return &err
+}
+
+// acquire claims the transaction for a query. release must be called afterwards iff acquire returns nil.
+func (t *wrappedTransaction) acquire(name string) error {
+	if t.opts.concurrentUse == ConcurrentUseSerialize {
+		t.busy.Lock()
+	} else if !t.busy.TryLock() {
+		if t.opts.strict {
+			panic(ErrConcurrentUse)
+		}
+		return ErrConcurrentUse
+	}
+	// inQuery is set before checking whether we were aborted, so abort either sees the query or we see the abort.
+	t.mu.Lock()
+	t.inQuery = true
+	t.current = name
+	t.mu.Unlock()
+	// checkUsable panics in strict mode, which must release too.
+	usable := false
+	defer func() {
+		if !usable {
+			t.release()
+		}
+	}()
+	if err := t.checkUsable(); err != nil {
+		return err
+	}
+	usable = true
+	return nil
+}
+
+func (t *wrappedTransaction) release() {
+	t.mu.Lock()
+	t.inQuery = false
+	t.current = ""
+	t.mu.Unlock()
+	t.busy.Unlock()
+}
+
+// currentQuery returns the name of the query that is in progress.
+func (t *wrappedTransaction) currentQuery() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+func (t *wrappedTransaction) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	name := QueryName(query)
+	ctx, e := t.startQuery(withQueryName(ctx, name), QueryOpExec, query, name, args)
+	if err := t.checkAccessMode(name, query); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	if err := t.acquire(name); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	t.countStatement(ctx, e, name, query)
+	defer t.release()
 	ct, err := t.tx.Exec(ctx, query, args...)
-	return ct, wrapError(err)
+	err = wrapQueryError(err, name)
+	t.endQuery(ctx, e, ct.RowsAffected(), err)
+	return ct, err
 }
 
-func (t wrappedTransaction) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+func (t *wrappedTransaction) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	return t.query(ctx, QueryOpQuery, query, args)
+}
+
+func (t *wrappedTransaction) query(ctx context.Context, op QueryOp, query string, args []interface{}) (pgx.Rows, error) {
+	name := QueryName(query)
+	ctx, e := t.startQuery(withQueryName(ctx, name), op, query, name, args)
+	if err := t.checkAccessMode(name, query); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	if err := t.acquire(name); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	t.countStatement(ctx, e, name, query)
 	rows, err := t.tx.Query(ctx, query, args...)
-	return rows, wrapError(err)
+	if err != nil {
+		err = wrapQueryError(err, name)
+		t.endQuery(ctx, e, 0, err)
+		t.release()
+		return nil, err
+	}
+	wr := &wrappedRows{
+		Rows:  rows,
+		name:  name,
+		limit: t.run.maxRows,
+	}
+	wr.release = func() {
+		t.endQuery(ctx, e, rows.CommandTag().RowsAffected(), wr.Err())
+		t.release()
+	}
+	return wr, nil
 }
 
-func (t wrappedTransaction) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+func (t *wrappedTransaction) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	desc := describeCopy(tableName, columnNames)
+	ctx, e := t.startQuery(ctx, QueryOpCopyFrom, desc, "", nil)
+	if err := t.checkAccessMode("", desc); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return 0, err
+	}
+	if err := t.acquire(""); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return 0, err
+	}
+	t.countStatement(ctx, e, "", desc)
+	defer t.release()
 	n, err := t.tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
-	return n, wrapError(err)
+	err = wrapError(err)
+	t.endQuery(ctx, e, n, err)
+	return n, err
 }
 
-func (t wrappedTransaction) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
-	rows, err := t.Query(ctx, query, args...)
+func (t *wrappedTransaction) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	rows, err := t.query(ctx, QueryOpQueryRow, query, args)
 	if err != nil {
 		return wrappedRowError{err}
 	}
 	return wrappedRow{rows}
 }
+
+func (t *wrappedTransaction) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	queries := batchQueries(b)
+	sql, name := describeBatch(queries)
+	ctx, e := t.startQuery(withQueryName(ctx, name), QueryOpBatch, sql, name, nil)
+	for _, sql := range queries {
+		if err := t.checkAccessMode(QueryName(sql), sql); err != nil {
+			t.endQuery(ctx, e, 0, err)
+			return wrappedBatchResultsError{err}
+		}
+	}
+	if err := t.acquire(""); err != nil {
+		t.endQuery(ctx, e, 0, err)
+		return wrappedBatchResultsError{err}
+	}
+	if queries == nil {
+		// We couldn't see what's in the batch, so count it as one statement.
+		t.countStatement(ctx, e, "", "batch")
+	}
+	for _, q := range queries {
+		t.countStatement(ctx, e, QueryName(q), q)
+	}
+	return &wrappedBatchResults{
+		br: t.tx.SendBatch(ctx, b),
+		finish: func(rowsAffected int64, err error) {
+			t.endQuery(ctx, e, rowsAffected, err)
+			t.release()
+		},
+	}
+}
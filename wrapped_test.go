@@ -0,0 +1,95 @@
+package trxwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestConcurrentUseWithOpenRows(t *testing.T) {
+	db := NewRaw(&fakeHandle{})
+	err := db.RunRWTransactionContext(context.Background(), pgx.ReadCommitted, func(ctx context.Context, r *Raw) error {
+		rows, err := r.DB.Query(ctx, "SELECT 1")
+		if err != nil {
+			return err
+		}
+		if _, err := r.DB.Exec(ctx, "SELECT 2"); err != ErrConcurrentUse {
+			t.Errorf("Exec with open Rows: got %v, want ErrConcurrentUse", err)
+		}
+		rows.Close()
+		if _, err := r.DB.Exec(ctx, "SELECT 3"); err != nil {
+			t.Errorf("Exec after Rows.Close: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunRWTransactionContext failed: %v", err)
+	}
+}
+
+func TestExhaustedRowsAreReleased(t *testing.T) {
+	db := NewRaw(&fakeHandle{})
+	err := db.RunRWTransactionContext(context.Background(), pgx.ReadCommitted, func(ctx context.Context, r *Raw) error {
+		rows, err := r.DB.Query(ctx, "SELECT 1")
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		if n != 2 {
+			t.Errorf("got %d rows, want 2", n)
+		}
+		if _, err := r.DB.Exec(ctx, "SELECT 2"); err != nil {
+			t.Errorf("Exec after reading all rows: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunRWTransactionContext failed: %v", err)
+	}
+}
+
+func TestConcurrentUseStrict(t *testing.T) {
+	db := NewRaw(&fakeHandle{}, WithStrictMode(), WithPanicsAsErrors())
+	err := db.RunRWTransactionContext(context.Background(), pgx.ReadCommitted, func(ctx context.Context, r *Raw) error {
+		if _, err := r.DB.Query(ctx, "SELECT 1"); err != nil {
+			return err
+		}
+		r.DB.Exec(ctx, "SELECT 2")
+		return nil
+	})
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != ErrConcurrentUse {
+		t.Errorf("got %v, want a panic with ErrConcurrentUse", err)
+	}
+}
+
+func TestConcurrentUseSerialize(t *testing.T) {
+	db := NewRaw(&fakeHandle{}, WithConcurrentUse(ConcurrentUseSerialize))
+	err := db.RunRWTransactionContext(context.Background(), pgx.ReadCommitted, func(ctx context.Context, r *Raw) error {
+		rows, err := r.DB.Query(ctx, "SELECT 1")
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.DB.Exec(ctx, "SELECT 2")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Errorf("Exec returned %v while Rows were open, want it to wait", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		rows.Close()
+		return <-done
+	})
+	if err != nil {
+		t.Fatalf("RunRWTransactionContext failed: %v", err)
+	}
+}